@@ -3,7 +3,9 @@ module github.com/candbright/util
 go 1.15
 
 require (
+	github.com/creack/pty v1.1.18
 	github.com/gin-gonic/gin v1.8.1
 	github.com/pkg/errors v0.9.1
-	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
+	github.com/pkg/sftp v1.13.5
+	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
 )
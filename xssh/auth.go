@@ -0,0 +1,242 @@
+package xssh
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrNoAuthMethod = errors.New("no usable ssh auth method")
+)
+
+// AuthMethod is one link of the ordered authentication chain of a Config.
+// Public key based methods (identity files, certificates and ssh-agent) are
+// offered to the server together, in the order they were added.
+type AuthMethod interface {
+	apply(chain *authChain) error
+}
+
+type authChain struct {
+	methods   []ssh.AuthMethod
+	signers   []ssh.Signer
+	publicKey bool
+	closers   []io.Closer
+}
+
+func (c *authChain) addMethod(method ssh.AuthMethod) {
+	c.methods = append(c.methods, method)
+}
+
+func (c *authChain) addSigners(signers ...ssh.Signer) {
+	c.signers = append(c.signers, signers...)
+	if !c.publicKey {
+		c.publicKey = true
+		c.addMethod(ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			return c.signers, nil
+		}))
+	}
+}
+
+func (c *authChain) Close() error {
+	for _, closer := range c.closers {
+		_ = closer.Close()
+	}
+	c.closers = nil
+	return nil
+}
+
+func newAuthChain(methods []AuthMethod) (*authChain, error) {
+	chain := &authChain{}
+	var errs []error
+	for _, method := range methods {
+		err := method.apply(chain)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(chain.methods) == 0 {
+		if len(errs) != 0 {
+			return nil, fmt.Errorf("%w: %v", ErrNoAuthMethod, errs)
+		}
+		return nil, ErrNoAuthMethod
+	}
+	return chain, nil
+}
+
+type passwordAuth struct {
+	password string
+}
+
+func PasswordAuth(password string) AuthMethod {
+	return &passwordAuth{password: password}
+}
+
+func (a *passwordAuth) apply(chain *authChain) error {
+	chain.addMethod(ssh.Password(a.password))
+	return nil
+}
+
+type keyboardInteractiveAuth struct {
+	challenge ssh.KeyboardInteractiveChallenge
+}
+
+func KeyboardInteractiveAuth(challenge ssh.KeyboardInteractiveChallenge) AuthMethod {
+	return &keyboardInteractiveAuth{challenge: challenge}
+}
+
+// PasswordKeyboardInteractiveAuth answers every keyboard-interactive question with password.
+func PasswordKeyboardInteractiveAuth(password string) AuthMethod {
+	return KeyboardInteractiveAuth(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range answers {
+			answers[i] = password
+		}
+		return answers, nil
+	})
+}
+
+func (a *keyboardInteractiveAuth) apply(chain *authChain) error {
+	chain.addMethod(ssh.KeyboardInteractive(a.challenge))
+	return nil
+}
+
+type keyFileAuth struct {
+	path       string
+	certPath   string
+	passphrase string
+}
+
+// KeyFileAuth loads a private key file. An OpenSSH certificate next to it
+// ("<path>-cert.pub") is picked up automatically.
+func KeyFileAuth(path string, passphrase ...string) AuthMethod {
+	auth := &keyFileAuth{path: path}
+	if len(passphrase) == 1 {
+		auth.passphrase = passphrase[0]
+	}
+	return auth
+}
+
+func CertificateAuth(keyPath string, certPath string, passphrase ...string) AuthMethod {
+	auth := &keyFileAuth{path: keyPath, certPath: certPath}
+	if len(passphrase) == 1 {
+		auth.passphrase = passphrase[0]
+	}
+	return auth
+}
+
+func (a *keyFileAuth) apply(chain *authChain) error {
+	signer, err := loadSigner(a.path, a.passphrase)
+	if err != nil {
+		return err
+	}
+	certPath := a.certPath
+	if certPath == "" {
+		certPath = a.path + "-cert.pub"
+		if !Exists(certPath) {
+			chain.addSigners(signer)
+			return nil
+		}
+	}
+	certSigner, err := loadCertSigner(certPath, signer)
+	if err != nil {
+		return err
+	}
+	chain.addSigners(certSigner, signer)
+	return nil
+}
+
+func loadSigner(path string, passphrase string) (ssh.Signer, error) {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("parse private key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+func loadCertSigner(certPath string, signer ssh.Signer) (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate %s: %w", certPath, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ssh certificate", certPath)
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+type agentAuth struct {
+	socket string
+}
+
+// AgentAuth uses the ssh-agent listening on socket, or on $SSH_AUTH_SOCK when socket is empty.
+func AgentAuth(socket ...string) AuthMethod {
+	auth := &agentAuth{}
+	if len(socket) == 1 {
+		auth.socket = socket[0]
+	}
+	return auth
+}
+
+func (a *agentAuth) apply(chain *authChain) error {
+	socket := a.socket
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		return errors.New("ssh-agent: SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return err
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if len(signers) == 0 {
+		_ = conn.Close()
+		return errors.New("ssh-agent: no identities")
+	}
+	chain.closers = append(chain.closers, conn)
+	chain.addSigners(signers...)
+	return nil
+}
+
+func defaultIdentityFiles() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	files := make([]string, 0)
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		file := filepath.Join(home, ".ssh", name)
+		if Exists(file) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
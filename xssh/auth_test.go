@@ -0,0 +1,122 @@
+package xssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeEncryptedKey(t *testing.T, dir string, passphrase string) (string, ssh.PublicKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte(passphrase), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "id_rsa")
+	if err = ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, pub
+}
+
+func TestAuthChain_EncryptedKey(t *testing.T) {
+	path, pub := writeEncryptedKey(t, t.TempDir(), "secret")
+
+	_, err := newAuthChain([]AuthMethod{KeyFileAuth(path)})
+	if !errors.Is(err, ErrNoAuthMethod) {
+		t.Fatalf("want %v, got %v", ErrNoAuthMethod, err)
+	}
+
+	chain, err := newAuthChain([]AuthMethod{KeyFileAuth(filepath.Join(t.TempDir(), "missing")), KeyFileAuth(path, "secret"), PasswordAuth("pw")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain.methods) != 2 || len(chain.signers) != 1 {
+		t.Fatalf("unexpected chain %+v", chain)
+	}
+	if string(chain.signers[0].PublicKey().Marshal()) != string(pub.Marshal()) {
+		t.Fatal("signer does not match key")
+	}
+}
+
+func TestAuthChain_Certificate(t *testing.T) {
+	dir := t.TempDir()
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, userKey, _ := ed25519.GenerateKey(rand.Reader)
+	userSigner, err := ssh.NewSignerFromKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := x509.MarshalPKCS8PrivateKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: block}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             userSigner.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"deploy"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err = cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyPath+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := newAuthChain([]AuthMethod{KeyFileAuth(keyPath)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain.signers) != 2 {
+		t.Fatalf("want certificate and key signers, got %d", len(chain.signers))
+	}
+	if _, ok := chain.signers[0].PublicKey().(*ssh.Certificate); !ok {
+		t.Fatal("certificate signer should be offered first")
+	}
+}
+
+func TestConfig_AuthMethods_KeyAndPassword(t *testing.T) {
+	_, config := startTestServer(t)
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: block}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.AddIdentityFile(path)
+	if auths := config.AuthMethods(); len(auths) != 3 {
+		t.Fatalf("want the key followed by the password methods, got %d methods", len(auths))
+	}
+	//the server does not know the key, the password must be tried next
+	session := &RemoteSession{Config: config}
+	if err = session.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	_ = session.Close()
+}
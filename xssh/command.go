@@ -0,0 +1,196 @@
+package xssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrUnquotable = errors.New("argument cannot be quoted for cmd.exe")
+)
+
+// Cmd builds a POSIX shell command line. Every argument is quoted unless it
+// is explicitly added as a raw shell fragment.
+type Cmd struct {
+	args  []cmdArg
+	stdin io.Reader
+	env   []string
+	dir   string
+	sudo  bool
+	//posix is set on commands in POSIX shell syntax, which the Windows command prompt cannot run
+	posix bool
+}
+
+type cmdArg struct {
+	value string
+	raw   bool
+}
+
+func NewCmd(name string, arg ...string) *Cmd {
+	c := &Cmd{}
+	return c.Arg(name).Arg(arg...)
+}
+
+func (c *Cmd) Arg(arg ...string) *Cmd {
+	for _, a := range arg {
+		c.args = append(c.args, cmdArg{value: a})
+	}
+	return c
+}
+
+// Raw appends a fragment that is passed to the shell unquoted, e.g. "|", "&&" or "2>&1".
+func (c *Cmd) Raw(fragment ...string) *Cmd {
+	for _, f := range fragment {
+		c.args = append(c.args, cmdArg{value: f, raw: true})
+	}
+	return c
+}
+
+func (c *Cmd) Stdin(stdin io.Reader) *Cmd {
+	c.stdin = stdin
+	return c
+}
+
+func (c *Cmd) Env(key string, value string) *Cmd {
+	c.env = append(c.env, key+"="+value)
+	return c
+}
+
+func (c *Cmd) Dir(dir string) *Cmd {
+	c.dir = dir
+	return c
+}
+
+// Sudo runs the command with sudo, answering the password prompt with Config.SudoPassword.
+func (c *Cmd) Sudo() *Cmd {
+	c.sudo = true
+	return c
+}
+
+func (c *Cmd) String() string {
+	parts := make([]string, len(c.args))
+	for i, arg := range c.args {
+		if arg.raw {
+			parts[i] = arg.value
+		} else {
+			parts[i] = Quote(arg.value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// script returns the command line prefixed with the working directory and,
+// when withEnv is set, the environment variables.
+func (c *Cmd) script(withEnv bool) string {
+	script := c.String()
+	if c.dir == "" && (!withEnv || len(c.env) == 0) {
+		return script
+	}
+	prefix := ""
+	if withEnv {
+		for _, env := range c.env {
+			kv := strings.SplitN(env, "=", 2)
+			prefix += "export " + kv[0] + "=" + Quote(kv[1]) + " && "
+		}
+	}
+	if c.dir != "" {
+		prefix += "cd -- " + Quote(c.dir) + " && "
+	}
+	return prefix + "{ " + script + "\n}"
+}
+
+func (c *Cmd) validate() error {
+	if len(c.args) == 0 {
+		return ErrEmptyCommand
+	}
+	for _, env := range c.env {
+		key := strings.SplitN(env, "=", 2)[0]
+		if !isEnvName(key) {
+			return fmt.Errorf("invalid environment variable name %q", key)
+		}
+	}
+	return nil
+}
+
+// validateLocal validates c for the shell of the local host, sh or the Windows
+// command prompt when windows is set.
+func (c *Cmd) validateLocal(windows bool) error {
+	err := c.validate()
+	if err != nil {
+		return err
+	}
+	if windows && c.posix {
+		return fmt.Errorf("%w: a pipeline requires a POSIX shell", ErrUnsupportedPlatform)
+	}
+	return nil
+}
+
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cmd) isRaw() bool {
+	for _, arg := range c.args {
+		if arg.raw {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Cmd) argv() []string {
+	argv := make([]string, len(c.args))
+	for i, arg := range c.args {
+		argv[i] = arg.value
+	}
+	return argv
+}
+
+// Quote returns s quoted for a POSIX shell so that it is always read back as a single word.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !isShellSafe(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func isShellSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("_-./:,@%+", r)
+}
+
+// windowsQuote returns s double quoted for cmd.exe, which reads &, |, <, > and
+// ^ literally between quotes. Quotes, line breaks and the % and ! of variable
+// expansion, which cmd.exe interprets even there, are rejected.
+func windowsQuote(s string) (string, error) {
+	if strings.ContainsAny(s, "\"%!\r\n\x00") {
+		return "", fmt.Errorf("%w: %q", ErrUnquotable, s)
+	}
+	return `"` + s + `"`, nil
+}
@@ -0,0 +1,154 @@
+package xssh
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var hostileArgs = []string{
+	"",
+	" ",
+	"two words",
+	"'",
+	"it's",
+	"''",
+	`"`,
+	`"double" 'single'`,
+	"$HOME",
+	"${PATH}",
+	"$(id)",
+	"`id`",
+	"a; touch /tmp/pwned",
+	"a && false",
+	"a || true",
+	"a | cat",
+	"> out",
+	"< in",
+	"2>&1",
+	"*",
+	"?",
+	"[a-z]",
+	"~",
+	"~root",
+	"#comment",
+	"!",
+	"!!",
+	"a\\b",
+	"\\",
+	"line1\nline2",
+	"tab\there",
+	"-n",
+	"--",
+	"a=b",
+	"{a,b}",
+	"&",
+	"(sub)",
+	"日本語",
+}
+
+func TestQuote(t *testing.T) {
+	cases := map[string]string{
+		"":           "''",
+		"abc":        "abc",
+		"/usr/bin":   "/usr/bin",
+		"a b":        "'a b'",
+		"it's":       `'it'\''s'`,
+		"$HOME":      "'$HOME'",
+		"a=b":        "'a=b'",
+		"--name=x y": "'--name=x y'",
+	}
+	for in, want := range cases {
+		if got := Quote(in); got != want {
+			t.Errorf("Quote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestWindowsQuote(t *testing.T) {
+	cases := map[string]string{
+		"":                   `""`,
+		`C:\Program Files\x`: `"C:\Program Files\x"`,
+		"a & calc":           `"a & calc"`,
+		"a | b > c < d ^":    `"a | b > c < d ^"`,
+		`a" & calc & "`:      "",
+		"%COMSPEC%":          "",
+		"!x!":                "",
+		"a\r\ncalc":          "",
+	}
+	for in, want := range cases {
+		got, err := windowsQuote(in)
+		if want == "" {
+			if !errors.Is(err, ErrUnquotable) {
+				t.Errorf("windowsQuote(%q) = %s, %v, want ErrUnquotable", in, got, err)
+			}
+		} else if got != want || err != nil {
+			t.Errorf("windowsQuote(%q) = %s, %v, want %s", in, got, err, want)
+		}
+	}
+	for _, arg := range hostileArgs {
+		got, err := windowsQuote(arg)
+		if err != nil {
+			continue
+		}
+		//cmd.exe reads everything up to the closing quote literally
+		if strings.ContainsAny(got[1:len(got)-1], "\"%!\n") {
+			t.Errorf("windowsQuote(%q) = %s", arg, got)
+		}
+	}
+}
+
+func TestCmd_String(t *testing.T) {
+	cmd := NewCmd("grep", "-r", "a b", "/tmp").Raw("|").Arg("wc", "-l").Raw("2>&1")
+	want := "grep -r 'a b' /tmp | wc -l 2>&1"
+	if got := cmd.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCmd_HostileArgs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	for _, arg := range hostileArgs {
+		output, err := exec.Command("sh", "-c", NewCmd("printf", "%s", arg).String()).Output()
+		if err != nil {
+			t.Fatalf("%q: %v", arg, err)
+		}
+		if string(output) != arg {
+			t.Errorf("got %q, want %q", output, arg)
+		}
+	}
+	output, err := exec.Command("sh", "-c", Command("printf", append([]string{"%s|"}, hostileArgs...)...)).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ""
+	for _, arg := range hostileArgs {
+		want += arg + "|"
+	}
+	if string(output) != want {
+		t.Errorf("got %q, want %q", output, want)
+	}
+}
+
+func TestCmd_Script(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	dir := t.TempDir()
+	cmd := NewCmd("pwd").Raw("&&").Arg("printf", "%s|%s").Raw(`"$FOO"`, `"$BAR"`).Env("FOO", "it's $x").Env("BAR", "a;b").Dir(dir)
+	output, err := exec.Command("sh", "-c", cmd.script(true)).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dir + "\nit's $x|a;b"
+	if string(output) != want {
+		t.Errorf("got %q, want %q", output, want)
+	}
+	if err = NewCmd("true").Env("A-B", "x").validate(); err == nil {
+		t.Error("want error for invalid environment variable name")
+	}
+}
@@ -1,12 +1,30 @@
 package xssh
 
+import (
+	"context"
+	"os"
+	"time"
+)
+
 type Config struct {
-	isLocal    bool
-	host       string
-	user       string
-	password   string
-	port       uint16
-	localHosts []string
+	isLocal        bool
+	host           string
+	user           string
+	password       string
+	port           uint16
+	localHosts     []string
+	hostKeyPolicy  HostKeyPolicy
+	knownHostsFile string
+	fingerprints   []string
+	auths          []AuthMethod
+	commandTimeout time.Duration
+	sudoPassword   string
+	jumpHosts      []Config
+	keepAlive      time.Duration
+	keepAliveMax   int
+	reconnect      ReconnectPolicy
+	onDisconnect   []DisconnectHook
+	onReconnect    []ReconnectHook
 }
 
 func NewConfig(isLocal bool, host string, user string, password string, port uint16) Config {
@@ -31,7 +49,12 @@ func (c *Config) AddLocalHost(host string) {
 	c.localHosts = append(c.localHosts, host)
 }
 
+// IsLocal reports whether commands run on this machine. A config with jump
+// hosts is always remote, its host is resolved by the last jump host.
 func (c *Config) IsLocal() bool {
+	if len(c.jumpHosts) != 0 {
+		return false
+	}
 	if c.host == "" {
 		c.host = "127.0.0.1"
 	}
@@ -74,3 +97,154 @@ func (c *Config) Port() uint16 {
 func (c *Config) Password() string {
 	return c.password
 }
+
+func (c *Config) SetHostKeyPolicy(policy HostKeyPolicy) {
+	c.hostKeyPolicy = policy
+}
+
+func (c *Config) HostKeyPolicy() HostKeyPolicy {
+	return c.hostKeyPolicy
+}
+
+func (c *Config) SetKnownHostsFile(path string) {
+	c.knownHostsFile = path
+}
+
+func (c *Config) KnownHostsFile() string {
+	if c.knownHostsFile == "" {
+		c.knownHostsFile = DefaultKnownHostsFile()
+	}
+	return c.knownHostsFile
+}
+
+func (c *Config) AddFingerprint(fingerprint string) {
+	for _, f := range c.fingerprints {
+		if f == fingerprint {
+			return
+		}
+	}
+	c.fingerprints = append(c.fingerprints, fingerprint)
+}
+
+func (c *Config) Fingerprints() []string {
+	return c.fingerprints
+}
+
+func (c *Config) AddAuth(auth ...AuthMethod) {
+	c.auths = append(c.auths, auth...)
+}
+
+func (c *Config) AddIdentityFile(path string, passphrase ...string) {
+	c.AddAuth(KeyFileAuth(path, passphrase...))
+}
+
+// AuthMethods returns the configured auth chain followed by the password, if
+// set, tried as password and keyboard-interactive auth. Without either,
+// ssh-agent and the default identity files of the current user are tried.
+func (c *Config) AuthMethods() []AuthMethod {
+	auths := make([]AuthMethod, 0, len(c.auths)+2)
+	auths = append(auths, c.auths...)
+	if c.password != "" {
+		auths = append(auths, PasswordAuth(c.password), PasswordKeyboardInteractiveAuth(c.password))
+	}
+	if len(auths) != 0 {
+		return auths
+	}
+	if os.Getenv("SSH_AUTH_SOCK") != "" {
+		auths = append(auths, AgentAuth())
+	}
+	for _, file := range defaultIdentityFiles() {
+		auths = append(auths, KeyFileAuth(file))
+	}
+	return auths
+}
+
+// SetCommandTimeout sets the default timeout of commands whose context has no deadline. Zero disables it.
+func (c *Config) SetCommandTimeout(timeout time.Duration) {
+	c.commandTimeout = timeout
+}
+
+func (c *Config) CommandTimeout() time.Duration {
+	return c.commandTimeout
+}
+
+func (c *Config) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); !ok && c.commandTimeout > 0 {
+		return context.WithTimeout(ctx, c.commandTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (c *Config) SetSudoPassword(password string) {
+	c.sudoPassword = password
+}
+
+// SudoPassword returns the password used to answer sudo prompts, which defaults to the login password.
+func (c *Config) SudoPassword() string {
+	if c.sudoPassword == "" {
+		return c.password
+	}
+	return c.sudoPassword
+}
+
+// AddJumpHost appends jump hosts to tunnel through, like ProxyJump. They are
+// connected in order, each one through the previous, and each uses its own
+// user, auth and host key policy.
+func (c *Config) AddJumpHost(jump ...Config) {
+	c.jumpHosts = append(c.jumpHosts, jump...)
+}
+
+func (c *Config) JumpHosts() []Config {
+	return c.jumpHosts
+}
+
+// SetKeepAlive sends a keepalive request every interval and considers the
+// connection lost after maxMissed requests in a row are unanswered. Zero disables it.
+func (c *Config) SetKeepAlive(interval time.Duration, maxMissed int) {
+	c.keepAlive = interval
+	c.keepAliveMax = maxMissed
+}
+
+func (c *Config) KeepAliveInterval() time.Duration {
+	return c.keepAlive
+}
+
+func (c *Config) KeepAliveMaxMissed() int {
+	if c.keepAliveMax <= 0 {
+		return 3
+	}
+	return c.keepAliveMax
+}
+
+func (c *Config) SetReconnect(policy ReconnectPolicy) {
+	c.reconnect = policy
+}
+
+func (c *Config) Reconnect() ReconnectPolicy {
+	policy := c.reconnect
+	if policy.Backoff <= 0 {
+		policy.Backoff = time.Second
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = 30 * time.Second
+	}
+	return policy
+}
+
+// OnDisconnect adds a hook called when the connection is found lost.
+func (c *Config) OnDisconnect(hook DisconnectHook) {
+	c.onDisconnect = append(c.onDisconnect, hook)
+}
+
+func (c *Config) DisconnectHooks() []DisconnectHook {
+	return c.onDisconnect
+}
+
+// OnReconnect adds a hook called after a lost connection has been reestablished.
+func (c *Config) OnReconnect(hook ReconnectHook) {
+	c.onReconnect = append(c.onReconnect, hook)
+}
+
+func (c *Config) ReconnectHooks() []ReconnectHook {
+	return c.onReconnect
+}
@@ -0,0 +1,91 @@
+package xssh
+
+import (
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strconv"
+	"time"
+)
+
+const dialTimeout = time.Second * 3
+
+// dialChain connects to config through its jump hosts. The clients of the
+// jump hosts are returned in order so that they can be closed with the connection.
+func dialChain(config Config) (*ssh.Client, []*ssh.Client, error) {
+	dial := func(network string, addr string) (net.Conn, error) {
+		return net.DialTimeout(network, addr, dialTimeout)
+	}
+	jumps := make([]*ssh.Client, 0)
+	for _, jump := range jumpChain(config) {
+		client, err := dialClient(jump, dial)
+		if err != nil {
+			_ = closeClient(nil, nil, jumps)
+			return nil, nil, fmt.Errorf("jump host %s: %w", jump.Host(), err)
+		}
+		jumps = append(jumps, client)
+		dial = client.Dial
+	}
+	client, err := dialClient(config, dial)
+	if err != nil {
+		_ = closeClient(nil, nil, jumps)
+		return nil, nil, err
+	}
+	return client, jumps, nil
+}
+
+// jumpChain flattens the jump hosts of config, including the jump hosts of the jump hosts.
+func jumpChain(config Config) []Config {
+	chain := make([]Config, 0)
+	for _, jump := range config.JumpHosts() {
+		chain = append(chain, jumpChain(jump)...)
+		chain = append(chain, jump)
+	}
+	return chain
+}
+
+func dialClient(config Config, dial dialFunc) (*ssh.Client, error) {
+	auth, err := newAuthChain(config.AuthMethods())
+	if err != nil {
+		return nil, err
+	}
+	defer auth.Close()
+	hostKeyChecker := newHostKeyChecker(config)
+	sshCfg := &ssh.ClientConfig{
+		Timeout:         dialTimeout,
+		User:            config.User(),
+		Auth:            auth.methods,
+		HostKeyCallback: hostKeyChecker.Check,
+	}
+	addr := net.JoinHostPort(config.Host(), strconv.Itoa(int(config.Port())))
+	conn, err := dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, sshCfg)
+	if err != nil {
+		_ = conn.Close()
+		if hostKeyChecker.err != nil {
+			return nil, hostKeyChecker.err
+		}
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// closeClient closes a connection opened by dialChain, the tunnels from the
+// innermost jump host outwards.
+func closeClient(sftpClient *sftp.Client, client *ssh.Client, jumps []*ssh.Client) error {
+	if sftpClient != nil {
+		_ = sftpClient.Close()
+	}
+	var err error
+	if client != nil {
+		err = client.Close()
+	}
+	for i := len(jumps) - 1; i >= 0; i-- {
+		_ = jumps[i].Close()
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package xssh
+
+import (
+	"errors"
+	"github.com/candbright/util/xssh/sshtest"
+	"golang.org/x/crypto/ssh"
+	"path/filepath"
+	"testing"
+)
+
+func TestJumpChain(t *testing.T) {
+	outer := SimpleConfig("outer")
+	inner := SimpleConfig("inner")
+	inner.AddJumpHost(outer)
+	config := SimpleConfig("127.0.0.1")
+	config.AddJumpHost(inner, SimpleConfig("last"))
+	if config.IsLocal() {
+		t.Fatal("a config with jump hosts must be remote")
+	}
+	chain := jumpChain(config)
+	hosts := make([]string, len(chain))
+	for i, jump := range chain {
+		hosts[i] = jump.Host()
+	}
+	if len(hosts) != 3 || hosts[0] != "outer" || hosts[1] != "inner" || hosts[2] != "last" {
+		t.Fatalf("unexpected chain %v", hosts)
+	}
+}
+
+func TestRemoteSession_JumpHost(t *testing.T) {
+	//the jump host takes a key and is checked by fingerprint
+	jump := sshtest.NewServer()
+	t.Cleanup(jump.Close)
+	jump.SetPassword(sshtest.DefaultUser, "other")
+	path, pub := writeEncryptedKey(t, t.TempDir(), "phrase")
+	jump.AddAuthorizedKey(sshtest.DefaultUser, pub)
+	jumpConfig := NewConfig(false, "localhost", sshtest.DefaultUser, "", jump.Port())
+	jumpConfig.AddAuth(KeyFileAuth(path, "phrase"))
+	jumpConfig.SetHostKeyPolicy(HostKeyFingerprint)
+	jumpConfig.AddFingerprint(ssh.FingerprintSHA256(jump.HostKey()))
+
+	//the target takes a password and is checked against known_hosts
+	target, config := startTestServer(t)
+	target.Handle("hostname", sshtest.Output("target\n", 0))
+	config.AddJumpHost(jumpConfig)
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	output, err := session.Output("hostname")
+	if err != nil || string(output) != "target\n" {
+		t.Fatalf("unexpected output %q %v", output, err)
+	}
+	if n := jump.Connections(); n != 1 {
+		t.Fatalf("want 1 connection to the jump host, got %d", n)
+	}
+	if commands := jump.Commands(); len(commands) != 0 {
+		t.Fatalf("want no command on the jump host, got %v", commands)
+	}
+	_ = session.Close()
+	waitConnections(t, jump, 0)
+
+	//without forwarding on the jump host the target cannot be reached
+	jump.SetForwarding(false)
+	session = &RemoteSession{Config: config}
+	if err = session.Connect(); err == nil {
+		t.Fatal("expected the tunnel to be refused")
+	}
+	jump.SetForwarding(true)
+
+	//each hop checks its own host key
+	config.SetKnownHostsFile(filepath.Join(t.TempDir(), "known_hosts"))
+	session = &RemoteSession{Config: config}
+	var hostKeyErr *HostKeyError
+	if err = session.Connect(); !errors.As(err, &hostKeyErr) {
+		t.Fatalf("want the unknown target key to be rejected, got %v", err)
+	}
+	wrongJump := NewConfig(false, "localhost", sshtest.DefaultUser, "", jump.Port())
+	wrongJump.AddAuth(KeyFileAuth(path, "phrase"))
+	wrongJump.SetHostKeyPolicy(HostKeyFingerprint)
+	wrongJump.AddFingerprint(ssh.FingerprintSHA256(target.HostKey()))
+	_, config = startTestServer(t)
+	config.AddJumpHost(wrongJump)
+	session = &RemoteSession{Config: config}
+	if err = session.Connect(); !errors.As(err, &hostKeyErr) {
+		t.Fatalf("want the jump host key to be rejected, got %v", err)
+	}
+}
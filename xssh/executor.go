@@ -0,0 +1,176 @@
+package xssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSkipped = errors.New("skipped after an earlier failure")
+)
+
+const DefaultParallel = 10
+
+// HostFunc is the work an Executor does on each host.
+type HostFunc func(ctx context.Context, session Session) (*Result, error)
+
+// Executor runs the same work on many hosts with a bounded parallelism.
+type Executor struct {
+	parallel int
+	failFast bool
+	pool     *Pool
+}
+
+// NewExecutor returns an executor working on at most parallel hosts at a time,
+// DefaultParallel when it is not positive.
+func NewExecutor(parallel int) *Executor {
+	if parallel <= 0 {
+		parallel = DefaultParallel
+	}
+	return &Executor{parallel: parallel}
+}
+
+// SetFailFast makes the executor cancel the running hosts and skip the
+// remaining ones after the first failure. By default it continues on errors.
+func (e *Executor) SetFailFast(failFast bool) {
+	e.failFast = failFast
+}
+
+// SetPool makes the executor take its sessions from pool instead of dialling
+// a new connection per host.
+func (e *Executor) SetPool(pool *Pool) {
+	e.pool = pool
+}
+
+type HostResult struct {
+	Host string
+	// Result is nil when the command could not be started, e.g. the host is unreachable.
+	Result *Result
+	Err    error
+	Start  time.Time
+	End    time.Time
+}
+
+func (r HostResult) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+func (r HostResult) Success() bool {
+	return r.Err == nil
+}
+
+type Summary struct {
+	// Results holds one result per host, in the order of the configs.
+	Results   []HostResult
+	Succeeded int
+	Failed    int
+	Skipped   int
+	Start     time.Time
+	End       time.Time
+}
+
+func (s *Summary) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Err returns nil when every host succeeded, otherwise an error counting the failures.
+func (s *Summary) Err() error {
+	if s.Failed == 0 && s.Skipped == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d hosts failed, %d skipped", s.Failed, len(s.Results), s.Skipped)
+}
+
+// FailedResults returns the results of the hosts that failed or were skipped.
+func (s *Summary) FailedResults() []HostResult {
+	results := make([]HostResult, 0)
+	for _, result := range s.Results {
+		if !result.Success() {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Run executes cmd on every host. cmd is shared between the hosts, so it
+// must not have a stdin.
+func (e *Executor) Run(ctx context.Context, configs []Config, cmd *Cmd) *Summary {
+	return e.Do(ctx, configs, func(ctx context.Context, session Session) (*Result, error) {
+		return session.Exec(ctx, cmd)
+	})
+}
+
+// Do connects to every host and calls fn with its session.
+func (e *Executor) Do(ctx context.Context, configs []Config, fn HostFunc) *Summary {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	summary := &Summary{Results: make([]HostResult, len(configs)), Start: time.Now()}
+	sem := make(chan struct{}, e.parallel)
+	wg := &sync.WaitGroup{}
+	for i, config := range configs {
+		started := false
+		select {
+		case sem <- struct{}{}:
+			started = ctx.Err() == nil
+			if !started {
+				<-sem
+			}
+		case <-ctx.Done():
+		}
+		if !started {
+			err := parent.Err()
+			if err == nil {
+				err = ErrSkipped
+			}
+			now := time.Now()
+			summary.Results[i] = HostResult{Host: config.Host(), Err: err, Start: now, End: now}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, config Config) {
+			defer wg.Done()
+			result := e.do(ctx, config, fn)
+			summary.Results[i] = result
+			if result.Err != nil && e.failFast {
+				cancel()
+			}
+			<-sem
+		}(i, config)
+	}
+	wg.Wait()
+	summary.End = time.Now()
+	for _, result := range summary.Results {
+		switch {
+		case result.Err == nil:
+			summary.Succeeded++
+		case errors.Is(result.Err, ErrSkipped):
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+func (e *Executor) do(ctx context.Context, config Config, fn HostFunc) HostResult {
+	result := HostResult{Host: config.Host(), Start: time.Now()}
+	var session Session
+	var err error
+	if e.pool != nil {
+		session, err = e.pool.Get(config)
+	} else {
+		session, err = NewSession(config)
+	}
+	if err != nil {
+		result.Err = err
+	} else {
+		result.Result, result.Err = fn(ctx, session)
+		_ = session.Close()
+	}
+	result.End = time.Now()
+	return result
+}
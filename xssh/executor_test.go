@@ -0,0 +1,74 @@
+package xssh
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func localConfigs(n int) []Config {
+	configs := make([]Config, n)
+	for i := range configs {
+		configs[i] = SimpleConfig("127.0.0.1")
+	}
+	return configs
+}
+
+func TestExecutor_Run(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires a POSIX shell")
+	}
+	summary := NewExecutor(2).Run(context.Background(), localConfigs(3), NewCmd("echo", "hello"))
+	if summary.Succeeded != 3 || summary.Err() != nil {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	for _, result := range summary.Results {
+		if string(result.Result.Stdout) != "hello\n" || result.Duration() <= 0 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	}
+}
+
+func TestExecutor_Parallel(t *testing.T) {
+	lock := &sync.Mutex{}
+	running, max := 0, 0
+	summary := NewExecutor(3).Do(context.Background(), localConfigs(10), func(ctx context.Context, session Session) (*Result, error) {
+		lock.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		running--
+		lock.Unlock()
+		return nil, nil
+	})
+	if summary.Succeeded != 10 || max != 3 {
+		t.Fatalf("expected 10 successes with at most 3 in parallel, got %d and %d", summary.Succeeded, max)
+	}
+}
+
+func TestExecutor_FailFast(t *testing.T) {
+	failure := errors.New("failure")
+	fn := func(ctx context.Context, session Session) (*Result, error) {
+		return nil, failure
+	}
+	executor := NewExecutor(1)
+	summary := executor.Do(context.Background(), localConfigs(3), fn)
+	if summary.Failed != 3 || summary.Skipped != 0 {
+		t.Fatalf("expected every host to run, got %+v", summary)
+	}
+	executor.SetFailFast(true)
+	summary = executor.Do(context.Background(), localConfigs(3), fn)
+	if summary.Failed != 1 || summary.Skipped != 2 || !errors.Is(summary.Results[2].Err, ErrSkipped) {
+		t.Fatalf("expected the remaining hosts to be skipped, got %+v", summary)
+	}
+	if len(summary.FailedResults()) != 3 || summary.Err() == nil {
+		t.Fatalf("unexpected failed results %+v", summary.FailedResults())
+	}
+}
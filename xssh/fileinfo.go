@@ -0,0 +1,229 @@
+package xssh
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileInfo describes a file of a session. It implements os.FileInfo.
+type FileInfo struct {
+	name    string
+	path    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	owner   string
+	group   string
+	link    string
+	sys     interface{}
+}
+
+var _ os.FileInfo = FileInfo{}
+
+// Name returns the base name of the file.
+func (f FileInfo) Name() string {
+	return f.name
+}
+
+// Path returns the name the file was looked up with, joined to its directory for ReadDir.
+func (f FileInfo) Path() string {
+	return f.path
+}
+
+func (f FileInfo) Size() int64 {
+	return f.size
+}
+
+func (f FileInfo) Mode() os.FileMode {
+	return f.mode
+}
+
+func (f FileInfo) ModTime() time.Time {
+	return f.modTime
+}
+
+func (f FileInfo) IsDir() bool {
+	return f.mode.IsDir()
+}
+
+// Sys returns the *syscall.Stat_t of local files and the *sftp.FileStat of
+// files read over sftp, nil otherwise.
+func (f FileInfo) Sys() interface{} {
+	return f.sys
+}
+
+// Owner returns the name of the user owning the file, its id when the name is unknown.
+func (f FileInfo) Owner() string {
+	return f.owner
+}
+
+// Group returns the name of the group of the file, its id when the name is unknown.
+func (f FileInfo) Group() string {
+	return f.group
+}
+
+// LinkTarget returns the target of a symbolic link, read with Lstat or
+// ReadDir. It is empty for other files.
+func (f FileInfo) LinkTarget() string {
+	return f.link
+}
+
+// newFileInfo converts info of the file at path, leaving the owner, the group
+// and the link target to the caller.
+func newFileInfo(path string, info os.FileInfo) FileInfo {
+	return FileInfo{
+		name:    info.Name(),
+		path:    path,
+		size:    info.Size(),
+		mode:    info.Mode(),
+		modTime: info.ModTime(),
+		sys:     info.Sys(),
+	}
+}
+
+// unixFileMode converts the st_mode of stat(2).
+func unixFileMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode & 0777)
+	switch mode & 0170000 {
+	case 0040000:
+		fileMode |= os.ModeDir
+	case 0120000:
+		fileMode |= os.ModeSymlink
+	case 0010000:
+		fileMode |= os.ModeNamedPipe
+	case 0140000:
+		fileMode |= os.ModeSocket
+	case 0020000:
+		fileMode |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		fileMode |= os.ModeDevice
+	}
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode
+}
+
+type lookupFunc func(id string) (string, error)
+
+// ownerLookup resolves user and group ids to names and remembers them. Ids
+// without a name are kept as they are.
+type ownerLookup struct {
+	lookupUser  lookupFunc
+	lookupGroup lookupFunc
+	users       map[uint32]string
+	groups      map[uint32]string
+}
+
+func newOwnerLookup(lookupUser lookupFunc, lookupGroup lookupFunc) *ownerLookup {
+	return &ownerLookup{
+		lookupUser:  lookupUser,
+		lookupGroup: lookupGroup,
+		users:       make(map[uint32]string),
+		groups:      make(map[uint32]string),
+	}
+}
+
+func (l *ownerLookup) user(uid uint32) string {
+	return lookupName(l.users, l.lookupUser, uid)
+}
+
+func (l *ownerLookup) group(gid uint32) string {
+	return lookupName(l.groups, l.lookupGroup, gid)
+}
+
+func lookupName(names map[uint32]string, lookup lookupFunc, id uint32) string {
+	name, ok := names[id]
+	if ok {
+		return name
+	}
+	name, err := lookup(strconv.FormatUint(uint64(id), 10))
+	if err != nil || name == "" {
+		name = strconv.FormatUint(uint64(id), 10)
+	}
+	names[id] = name
+	return name
+}
+
+func localOwners() *ownerLookup {
+	return newOwnerLookup(func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	}, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+// idNames parses /etc/passwd or /etc/group into names by id.
+func idNames(data []byte) map[string]string {
+	names := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if _, ok := names[fields[2]]; !ok {
+			names[fields[2]] = fields[0]
+		}
+	}
+	return names
+}
+
+// localStat reads name with stat, which is os.Stat or os.Lstat.
+func localStat(name string, stat func(name string) (os.FileInfo, error), owners *ownerLookup) (FileInfo, error) {
+	info, err := stat(name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return localFileInfo(name, info, owners)
+}
+
+func localFileInfo(path string, info os.FileInfo, owners *ownerLookup) (FileInfo, error) {
+	file := newFileInfo(path, info)
+	uid, gid, ok := fileOwnerIDs(info)
+	if ok {
+		file.owner, file.group = owners.user(uid), owners.group(gid)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return FileInfo{}, err
+		}
+		file.link = link
+	}
+	return file, nil
+}
+
+func localReadDir(dir string) ([]FileInfo, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	owners := localOwners()
+	files := make([]FileInfo, len(infos))
+	for i, info := range infos {
+		files[i], err = localFileInfo(filepath.Join(dir, info.Name()), info, owners)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
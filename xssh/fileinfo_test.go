@@ -0,0 +1,136 @@
+package xssh
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func writeStatTree(t *testing.T) string {
+	dir := t.TempDir()
+	mtime := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	name := filepath.Join(dir, "file")
+	err := ioutil.WriteFile(name, []byte("content"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(name, mtime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(filepath.Join(dir, "sub"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink("file", filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLocalSession_Stat(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs symbolic links and stat(1)")
+	}
+	dir := writeStatTree(t)
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &LocalSession{}
+	link, err := session.Lstat(filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Mode()&os.ModeSymlink == 0 || link.LinkTarget() != "file" || link.Owner() != current.Username {
+		t.Fatalf("unexpected link %+v", link)
+	}
+	file, err := session.Stat(filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name() != "link" || file.Size() != 7 || file.Mode() != 0640 || file.LinkTarget() != "" {
+		t.Fatalf("unexpected file %+v", file)
+	}
+	_, err = session.Stat(filepath.Join(dir, "missing"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+	_, err = session.shell().Stat(filepath.Join(dir, "missing"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error from the shell, got %v", err)
+	}
+}
+
+// TestLocalSession_ReadDir checks that the shell commands used remotely and
+// with sudo describe files like the local file system does.
+func TestLocalSession_ReadDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs symbolic links and stat(1)")
+	}
+	dir := writeStatTree(t)
+	session := &LocalSession{}
+	files, err := session.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shellFiles, err := session.shell().ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || len(shellFiles) != 3 {
+		t.Fatalf("expected 3 files, got %d and %d", len(files), len(shellFiles))
+	}
+	for i, file := range files {
+		shellFile := shellFiles[i]
+		if file.Name() != shellFile.Name() || file.Path() != shellFile.Path() || file.Size() != shellFile.Size() ||
+			file.Mode() != shellFile.Mode() || !file.ModTime().Truncate(time.Second).Equal(shellFile.ModTime()) ||
+			file.Owner() != shellFile.Owner() || file.Group() != shellFile.Group() || file.LinkTarget() != shellFile.LinkTarget() {
+			t.Fatalf("files differ:\n%+v\n%+v", file, shellFile)
+		}
+	}
+	if !files[2].IsDir() || files[2].Path() != filepath.Join(dir, "sub") || files[2].Mode().Perm() != 0750 {
+		t.Fatalf("unexpected directory %+v", files[2])
+	}
+}
+
+func TestUnixFileMode(t *testing.T) {
+	tests := []struct {
+		mode uint32
+		want os.FileMode
+	}{
+		{mode: 0100644, want: 0644},
+		{mode: 040755, want: os.ModeDir | 0755},
+		{mode: 0120777, want: os.ModeSymlink | 0777},
+		{mode: 041777, want: os.ModeDir | os.ModeSticky | 0777},
+		{mode: 0104755, want: os.ModeSetuid | 0755},
+		{mode: 020620, want: os.ModeDevice | os.ModeCharDevice | 0620},
+	}
+	for _, tt := range tests {
+		if got := unixFileMode(tt.mode); got != tt.want {
+			t.Errorf("unixFileMode(%o) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestRemoteSession_StatUnsupported(t *testing.T) {
+	session := &RemoteSession{Config: startExecServer(t)}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	session.platform = &Platform{Family: FamilyWindows}
+	for _, stat := range []func(string) (FileInfo, error){session.Stat, session.Lstat} {
+		info, err := stat("C:\\file")
+		if !errors.Is(err, ErrUnsupportedPlatform) || info.Name() != "" {
+			t.Fatalf("expected ErrUnsupportedPlatform, got %+v %v", info, err)
+		}
+	}
+}
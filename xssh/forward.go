@@ -0,0 +1,322 @@
+package xssh
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSocksVersion     = errors.New("socks: unsupported version")
+	ErrSocksAuth        = errors.New("socks: no acceptable authentication method")
+	ErrSocksCommand     = errors.New("socks: unsupported command")
+	ErrSocksAddressType = errors.New("socks: unsupported address type")
+)
+
+const socksHandshakeTimeout = 10 * time.Second
+
+type dialFunc func(network string, addr string) (net.Conn, error)
+
+// connectFunc prepares an accepted connection and returns the connection to forward it to.
+type connectFunc func(conn net.Conn) (net.Conn, error)
+
+// Forward is a running port forwarding. It accepts connections until it is closed.
+type Forward struct {
+	listener net.Listener
+	connect  connectFunc
+	wg       sync.WaitGroup
+	lock     sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+func newForward(listener net.Listener, connect connectFunc) *Forward {
+	f := &Forward{
+		listener: listener,
+		connect:  connect,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	f.wg.Add(1)
+	go f.serve()
+	return f
+}
+
+// Addr returns the address the forwarding listens on, which is a remote
+// address for RemoteForward.
+func (f *Forward) Addr() net.Addr {
+	return f.listener.Addr()
+}
+
+// ActiveConnections returns the number of connections currently being forwarded.
+func (f *Forward) ActiveConnections() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.conns)
+}
+
+// Close stops accepting connections, closes the active ones and waits for them to finish.
+func (f *Forward) Close() error {
+	f.lock.Lock()
+	if f.closed {
+		f.lock.Unlock()
+		return nil
+	}
+	f.closed = true
+	err := f.listener.Close()
+	for conn := range f.conns {
+		_ = conn.Close()
+	}
+	f.lock.Unlock()
+	f.wg.Wait()
+	return err
+}
+
+func (f *Forward) serve() {
+	defer f.wg.Done()
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !f.track(conn) {
+			_ = conn.Close()
+			return
+		}
+		f.wg.Add(1)
+		go f.handle(conn)
+	}
+}
+
+func (f *Forward) track(conn net.Conn) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return false
+	}
+	f.conns[conn] = struct{}{}
+	return true
+}
+
+func (f *Forward) untrack(conn net.Conn) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.conns, conn)
+}
+
+func (f *Forward) handle(conn net.Conn) {
+	defer f.wg.Done()
+	defer f.untrack(conn)
+	defer conn.Close()
+	peer, err := f.connect(conn)
+	if err != nil {
+		return
+	}
+	defer peer.Close()
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pipe(peer, conn)
+	}()
+	go func() {
+		defer wg.Done()
+		pipe(conn, peer)
+	}()
+	wg.Wait()
+}
+
+// pipe copies src to dst and half-closes dst, so that protocols which shut
+// down their write side still receive the response.
+func pipe(dst net.Conn, src net.Conn) {
+	_, err := io.Copy(dst, src)
+	if closer, ok := dst.(interface{ CloseWrite() error }); ok && err == nil {
+		_ = closer.CloseWrite()
+		return
+	}
+	_ = dst.Close()
+	_ = src.Close()
+}
+
+// dial opens a connection from the remote host on the connection in use when
+// it is called, reconnecting first when it was lost.
+func (s *RemoteSession) dial(network string, addr string) (net.Conn, error) {
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	client, _, _ := s.current()
+	if client == nil {
+		return nil, ErrNilSshClient
+	}
+	return client.Dial(network, addr)
+}
+
+// LocalForward listens on localAddr and forwards every connection to remoteAddr
+// as seen from the remote host, like ssh -L.
+func (s *RemoteSession) LocalForward(localAddr string, remoteAddr string) (*Forward, error) {
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	return newForward(listener, func(net.Conn) (net.Conn, error) {
+		return s.dial("tcp", remoteAddr)
+	}), nil
+}
+
+// RemoteForward listens on remoteAddr on the remote host and forwards every
+// connection to localAddr, like ssh -R.
+func (s *RemoteSession) RemoteForward(remoteAddr string, localAddr string) (*Forward, error) {
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	client, _, _ := s.current()
+	if client == nil {
+		return nil, ErrNilSshClient
+	}
+	listener, err := client.Listen("tcp", remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return newForward(listener, func(net.Conn) (net.Conn, error) {
+		return net.Dial("tcp", localAddr)
+	}), nil
+}
+
+// DynamicForward runs a SOCKS5 proxy on localAddr which opens its connections
+// from the remote host, like ssh -D. Only the CONNECT command without
+// authentication is supported.
+func (s *RemoteSession) DynamicForward(localAddr string) (*Forward, error) {
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	return newForward(listener, socksConnect(s.dial)), nil
+}
+
+const (
+	socksVersion      = 0x05
+	socksNoAuth       = 0x00
+	socksNoAcceptable = 0xff
+	socksConnectCmd   = 0x01
+	socksAddrIPv4     = 0x01
+	socksAddrDomain   = 0x03
+	socksAddrIPv6     = 0x04
+	socksSucceeded    = 0x00
+	socksHostUnreach  = 0x04
+	socksCmdNotSupp   = 0x07
+	socksAddrNotSupp  = 0x08
+)
+
+func socksConnect(dial dialFunc) connectFunc {
+	return func(conn net.Conn) (net.Conn, error) {
+		_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+		addr, err := socksHandshake(conn)
+		if err != nil {
+			return nil, err
+		}
+		peer, err := dial("tcp", addr)
+		if err != nil {
+			_ = socksReply(conn, socksHostUnreach)
+			return nil, err
+		}
+		err = socksReply(conn, socksSucceeded)
+		if err != nil {
+			_ = peer.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return peer, nil
+	}
+}
+
+// socksHandshake reads the greeting and the request of a SOCKS5 client (RFC 1928)
+// and returns the address it asks to connect to.
+func socksHandshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", ErrSocksVersion
+	}
+	methods := make([]byte, header[1])
+	_, err = io.ReadFull(conn, methods)
+	if err != nil {
+		return "", err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	_, err = conn.Write([]byte{socksVersion, method})
+	if err != nil {
+		return "", err
+	}
+	if method == socksNoAcceptable {
+		return "", ErrSocksAuth
+	}
+	request := make([]byte, 4)
+	_, err = io.ReadFull(conn, request)
+	if err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion {
+		return "", ErrSocksVersion
+	}
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make([]byte, net.IPv4len)
+		if request[3] == socksAddrIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		_, err = io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		_, err = io.ReadFull(conn, length)
+		if err == nil {
+			domain := make([]byte, length[0])
+			_, err = io.ReadFull(conn, domain)
+			host = string(domain)
+		}
+	default:
+		_ = socksReply(conn, socksAddrNotSupp)
+		return "", ErrSocksAddressType
+	}
+	if err != nil {
+		return "", err
+	}
+	port := make([]byte, 2)
+	_, err = io.ReadFull(conn, port)
+	if err != nil {
+		return "", err
+	}
+	if request[1] != socksConnectCmd {
+		_ = socksReply(conn, socksCmdNotSupp)
+		return "", ErrSocksCommand
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,214 @@
+package xssh
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestForward_Socks(t *testing.T) {
+	echo := startEchoServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward := newForward(listener, socksConnect(net.Dial))
+	defer forward.Close()
+
+	conn, err := net.Dial("tcp", forward.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	port := echo.Addr().(*net.TCPAddr).Port
+	request := []byte{socksVersion, 1, socksNoAuth}
+	request = append(request, socksVersion, socksConnectCmd, 0, socksAddrDomain, 9)
+	request = append(request, "localhost"...)
+	request = append(request, byte(port>>8), byte(port))
+	_, err = conn.Write(append(request, "ping\n"...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	reply := make([]byte, 12)
+	_, err = io.ReadFull(reader, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply[1] != socksNoAuth || reply[3] != socksSucceeded {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Fatalf("unexpected echo %q %v", line, err)
+	}
+	if n := forward.ActiveConnections(); n != 1 {
+		t.Fatalf("expected 1 active connection, got %d", n)
+	}
+
+	err = forward.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := forward.ActiveConnections(); n != 0 {
+		t.Fatalf("expected no active connection after close, got %d", n)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = reader.ReadByte()
+	if err != io.EOF {
+		t.Fatalf("expected EOF after close, got %v", err)
+	}
+}
+
+func TestForward_SocksUnsupportedCommand(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		_, _ = client.Write([]byte{socksVersion, 1, socksNoAuth, socksVersion, 0x02, 0, socksAddrIPv4, 127, 0, 0, 1, 0, 80})
+	}()
+	done := make(chan error)
+	go func() {
+		_, err := socksHandshake(server)
+		done <- err
+	}()
+	reply := make([]byte, 12)
+	_, err := io.ReadFull(client, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply[3] != socksCmdNotSupp {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+	if err := <-done; err != ErrSocksCommand {
+		t.Fatalf("expected ErrSocksCommand, got %v", err)
+	}
+}
+
+// ping writes a line to conn and expects it back.
+func ping(t *testing.T, conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write([]byte("ping\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Fatalf("unexpected echo %q %v", line, err)
+	}
+}
+
+func TestRemoteSession_LocalForward(t *testing.T) {
+	echo := startEchoServer(t)
+	server, config := startTestServer(t)
+	config.SetReconnect(ReconnectPolicy{MaxAttempts: 3, Backoff: 10 * time.Millisecond})
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	forward, err := session.LocalForward("127.0.0.1:0", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forward.Close()
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", forward.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ping(t, conn)
+		_ = conn.Close()
+		//the next connection goes through the new ssh connection
+		server.CloseConnections()
+		waitConnections(t, server, 0)
+	}
+}
+
+func TestRemoteSession_RemoteForward(t *testing.T) {
+	echo := startEchoServer(t)
+	_, config := startTestServer(t)
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	forward, err := session.RemoteForward("127.0.0.1:0", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forward.Close()
+	conn, err := net.Dial("tcp", forward.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ping(t, conn)
+}
+
+func TestRemoteSession_DynamicForward(t *testing.T) {
+	echo := startEchoServer(t)
+	_, config := startTestServer(t)
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	forward, err := session.DynamicForward("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forward.Close()
+	conn, err := net.Dial("tcp", forward.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	port := echo.Addr().(*net.TCPAddr).Port
+	request := []byte{socksVersion, 1, socksNoAuth}
+	request = append(request, socksVersion, socksConnectCmd, 0, socksAddrIPv4, 127, 0, 0, 1)
+	request = append(request, byte(port>>8), byte(port))
+	_, err = conn.Write(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 12)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply[1] != socksNoAuth || reply[3] != socksSucceeded {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+	ping(t, conn)
+	if n := forward.ActiveConnections(); n != 1 {
+		t.Fatalf("expected 1 active connection, got %d", n)
+	}
+}
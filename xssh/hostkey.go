@@ -0,0 +1,136 @@
+package xssh
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"strings"
+)
+
+type HostKeyPolicy int
+
+const (
+	// HostKeyStrict, the default, only accepts keys already present in the
+	// known_hosts file, DefaultKnownHostsFile unless Config.SetKnownHostsFile is called.
+	HostKeyStrict HostKeyPolicy = iota
+	// HostKeyInsecure accepts any host key. It must be set explicitly.
+	HostKeyInsecure
+	// HostKeyTrustOnFirstUse appends unknown keys to the known_hosts file and rejects changed ones.
+	HostKeyTrustOnFirstUse
+	// HostKeyFingerprint only accepts keys whose fingerprint was added with Config.AddFingerprint.
+	HostKeyFingerprint
+)
+
+func (p HostKeyPolicy) String() string {
+	switch p {
+	case HostKeyStrict:
+		return "strict"
+	case HostKeyInsecure:
+		return "insecure"
+	case HostKeyTrustOnFirstUse:
+		return "trust-on-first-use"
+	case HostKeyFingerprint:
+		return "fingerprint"
+	default:
+		return fmt.Sprintf("HostKeyPolicy(%d)", int(p))
+	}
+}
+
+// HostKeyError is returned by Connect when the server presents a key that is
+// unknown or does not match the configured policy.
+type HostKeyError struct {
+	Host        string
+	Fingerprint string
+	// Want holds the fingerprints of the accepted keys. It is empty if the host is unknown.
+	Want    []string
+	Revoked bool
+}
+
+func (e *HostKeyError) Error() string {
+	switch {
+	case e.Revoked:
+		return fmt.Sprintf("host key %s for %s is revoked", e.Fingerprint, e.Host)
+	case len(e.Want) == 0:
+		return fmt.Sprintf("host key %s for %s is unknown", e.Fingerprint, e.Host)
+	default:
+		return fmt.Sprintf("host key mismatch for %s: got %s, want %s", e.Host, e.Fingerprint, strings.Join(e.Want, ", "))
+	}
+}
+
+func (e *HostKeyError) Mismatch() bool {
+	return len(e.Want) != 0
+}
+
+type hostKeyChecker struct {
+	policy         HostKeyPolicy
+	knownHostsFile string
+	fingerprints   []string
+	err            error
+}
+
+func newHostKeyChecker(config Config) *hostKeyChecker {
+	return &hostKeyChecker{
+		policy:         config.HostKeyPolicy(),
+		knownHostsFile: config.KnownHostsFile(),
+		fingerprints:   config.Fingerprints(),
+	}
+}
+
+func (c *hostKeyChecker) Check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	c.err = c.check(hostname, remote, key)
+	return c.err
+}
+
+func (c *hostKeyChecker) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	switch c.policy {
+	case HostKeyInsecure:
+		return nil
+	case HostKeyFingerprint:
+		return c.checkFingerprint(hostname, key)
+	case HostKeyStrict, HostKeyTrustOnFirstUse:
+		return c.checkKnownHosts(hostname, remote, key)
+	default:
+		return fmt.Errorf("unsupported host key policy %s", c.policy)
+	}
+}
+
+func (c *hostKeyChecker) checkFingerprint(hostname string, key ssh.PublicKey) error {
+	sha256 := ssh.FingerprintSHA256(key)
+	md5 := ssh.FingerprintLegacyMD5(key)
+	for _, f := range c.fingerprints {
+		if f == sha256 || strings.TrimPrefix(f, "MD5:") == md5 {
+			return nil
+		}
+	}
+	return &HostKeyError{Host: hostname, Fingerprint: sha256, Want: c.fingerprints}
+}
+
+func (c *hostKeyChecker) checkKnownHosts(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	knownHosts := NewKnownHosts(c.knownHostsFile)
+	callback, err := knownHosts.callback()
+	if err != nil {
+		return err
+	}
+	err = callback(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+	var revokedErr *knownhosts.RevokedError
+	if errors.As(err, &revokedErr) {
+		return &HostKeyError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Revoked: true}
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return err
+	}
+	if len(keyErr.Want) == 0 && c.policy == HostKeyTrustOnFirstUse {
+		return knownHosts.Add(hostname, key)
+	}
+	want := make([]string, len(keyErr.Want))
+	for i, known := range keyErr.Want {
+		want[i] = ssh.FingerprintSHA256(known.Key)
+	}
+	return &HostKeyError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Want: want}
+}
@@ -0,0 +1,586 @@
+package xssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInventorySyntax = errors.New("inventory syntax error")
+	ErrUnknownHost     = errors.New("unknown host")
+)
+
+const (
+	// GroupAll contains every host of an inventory.
+	GroupAll = "all"
+	// GroupUngrouped contains the hosts which belong to no other group.
+	GroupUngrouped = "ungrouped"
+)
+
+// Inventory is a list of hosts and groups in the INI format of Ansible:
+//
+//	bastion.example.com ansible_user=jump
+//
+//	[web]
+//	web[01:03].example.com
+//	web-test ansible_host=10.0.0.9 ansible_port=2222
+//
+//	[db]
+//	db1.example.com ansible_ssh_private_key_file=~/.ssh/db
+//
+//	[prod:children]
+//	web
+//	db
+//
+//	[prod:vars]
+//	ansible_user=deploy
+//
+// The variables of a host are those of its groups, the groups nested deeper
+// overriding their parents and GroupAll, then its own. Config reads the
+// connection variables of Ansible, see Inventory.Config.
+type Inventory struct {
+	hosts  []string
+	vars   map[string]map[string]string
+	groups map[string]*inventoryGroup
+	order  []string
+}
+
+type inventoryGroup struct {
+	hosts    []string
+	children []string
+	parents  []string
+	vars     map[string]string
+	depth    int
+}
+
+func LoadInventory(path string) (*Inventory, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	inv, err := ParseInventory(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return inv, nil
+}
+
+func ParseInventory(r io.Reader) (*Inventory, error) {
+	inv := &Inventory{
+		vars:   make(map[string]map[string]string),
+		groups: make(map[string]*inventoryGroup),
+	}
+	inv.group(GroupAll)
+	inv.group(GroupUngrouped)
+	group, kind := GroupUngrouped, ""
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' || text[0] == ';' {
+			continue
+		}
+		var err error
+		if text[0] == '[' {
+			group, kind, err = parseInventorySection(text)
+			if err == nil {
+				inv.group(group)
+			}
+		} else {
+			err = inv.parseLine(text, group, kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, name := range inv.order {
+		g := inv.groups[name]
+		if name != GroupAll && len(g.parents) == 0 {
+			inv.addChild(GroupAll, name)
+		}
+	}
+	err := inv.setDepth(GroupAll, 0, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range inv.order {
+		//only the groups nested in themselves are not reached from GroupAll
+		if name != GroupAll && inv.groups[name].depth == 0 {
+			return nil, fmt.Errorf("%w: group %s is its own child", ErrInventorySyntax, name)
+		}
+	}
+	ungrouped := inv.groups[GroupUngrouped]
+	for _, host := range inv.hosts {
+		if len(inv.hostGroups(host)) == 1 {
+			ungrouped.hosts = append(ungrouped.hosts, host)
+		}
+	}
+	return inv, nil
+}
+
+func parseInventorySection(text string) (string, string, error) {
+	if !strings.HasSuffix(text, "]") {
+		return "", "", fmt.Errorf("%w: unterminated section %s", ErrInventorySyntax, text)
+	}
+	name := strings.TrimSpace(text[1 : len(text)-1])
+	kind := ""
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name, kind = name[:i], name[i+1:]
+		if kind != "vars" && kind != "children" {
+			return "", "", fmt.Errorf("%w: unknown section type %s", ErrInventorySyntax, kind)
+		}
+	}
+	if name == "" || strings.ContainsAny(name, " \t:[]") {
+		return "", "", fmt.Errorf("%w: invalid group name %q", ErrInventorySyntax, name)
+	}
+	return name, kind, nil
+}
+
+func (inv *Inventory) parseLine(text string, group string, kind string) error {
+	switch kind {
+	case "vars":
+		kv := strings.SplitN(text, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return fmt.Errorf("%w: expected key=value, got %q", ErrInventorySyntax, text)
+		}
+		value, err := unquoteInventoryValue(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return err
+		}
+		inv.groups[group].vars[key] = value
+	case "children":
+		if strings.ContainsAny(text, " \t:[]") {
+			return fmt.Errorf("%w: invalid group name %q", ErrInventorySyntax, text)
+		}
+		inv.group(text)
+		inv.addChild(group, text)
+	default:
+		fields, err := splitInventoryFields(text)
+		if err != nil {
+			return err
+		}
+		names, err := expandHostRange(fields[0])
+		if err != nil {
+			return err
+		}
+		vars := make(map[string]string)
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return fmt.Errorf("%w: expected key=value, got %q", ErrInventorySyntax, field)
+			}
+			vars[kv[0]] = kv[1]
+		}
+		for _, name := range names {
+			inv.addHost(name, group, vars)
+		}
+	}
+	return nil
+}
+
+// splitInventoryFields splits a host line at spaces, keeping quoted values,
+// e.g. key="a b", together and unquoted.
+func splitInventoryFields(text string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	var quote byte
+	inField := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote, inField = c, true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		case c == '#':
+			//the rest of the line is a comment
+			i = len(text)
+		default:
+			field.WriteByte(c)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("%w: unterminated quote in %q", ErrInventorySyntax, text)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: missing host in %q", ErrInventorySyntax, text)
+	}
+	return fields, nil
+}
+
+func unquoteInventoryValue(value string) (string, error) {
+	if len(value) != 0 && (value[0] == '"' || value[0] == '\'') {
+		if len(value) < 2 || value[len(value)-1] != value[0] {
+			return "", fmt.Errorf("%w: unterminated quote in %q", ErrInventorySyntax, value)
+		}
+		return value[1 : len(value)-1], nil
+	}
+	return value, nil
+}
+
+var hostRangePattern = regexp.MustCompile(`\[([0-9a-zA-Z]+):([0-9a-zA-Z]+)(?::([0-9]+))?\]`)
+
+// expandHostRange expands the ranges of a host pattern, "[01:03]" to 01, 02
+// and 03 and "[a:c]" to a, b and c. A third number is the step.
+func expandHostRange(pattern string) ([]string, error) {
+	loc := hostRangePattern.FindStringSubmatchIndex(pattern)
+	if loc == nil {
+		if strings.ContainsAny(pattern, "[]") {
+			return nil, fmt.Errorf("%w: invalid host range %q", ErrInventorySyntax, pattern)
+		}
+		return []string{pattern}, nil
+	}
+	start, end := pattern[loc[2]:loc[3]], pattern[loc[4]:loc[5]]
+	step := 1
+	if loc[6] >= 0 {
+		step, _ = strconv.Atoi(pattern[loc[6]:loc[7]])
+	}
+	var values []string
+	first, err1 := strconv.Atoi(start)
+	last, err2 := strconv.Atoi(end)
+	switch {
+	case err1 == nil && err2 == nil:
+		format := "%d"
+		if len(start) > 1 && start[0] == '0' {
+			format = "%0" + strconv.Itoa(len(start)) + "d"
+		}
+		for n := first; n <= last && step > 0; n += step {
+			values = append(values, fmt.Sprintf(format, n))
+		}
+	case len(start) == 1 && len(end) == 1 && err1 != nil && err2 != nil:
+		for c := int(start[0]); c <= int(end[0]) && step > 0; c += step {
+			values = append(values, string(rune(c)))
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%w: invalid host range %q", ErrInventorySyntax, pattern[loc[0]:loc[1]])
+	}
+	rest, err := expandHostRange(pattern[loc[1]:])
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, value := range values {
+		for _, r := range rest {
+			names = append(names, pattern[:loc[0]]+value+r)
+		}
+	}
+	return names, nil
+}
+
+func (inv *Inventory) group(name string) *inventoryGroup {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &inventoryGroup{vars: make(map[string]string)}
+		inv.groups[name] = g
+		inv.order = append(inv.order, name)
+	}
+	return g
+}
+
+func (inv *Inventory) addChild(parent string, child string) {
+	g := inv.groups[parent]
+	for _, c := range g.children {
+		if c == child {
+			return
+		}
+	}
+	g.children = append(g.children, child)
+	inv.groups[child].parents = append(inv.groups[child].parents, parent)
+}
+
+func (inv *Inventory) addHost(name string, group string, vars map[string]string) {
+	hostVars, ok := inv.vars[name]
+	if !ok {
+		hostVars = make(map[string]string)
+		inv.vars[name] = hostVars
+		inv.hosts = append(inv.hosts, name)
+		inv.groups[GroupAll].hosts = append(inv.groups[GroupAll].hosts, name)
+	}
+	for key, value := range vars {
+		hostVars[key] = value
+	}
+	if group == GroupUngrouped {
+		return
+	}
+	g := inv.groups[group]
+	for _, host := range g.hosts {
+		if host == name {
+			return
+		}
+	}
+	g.hosts = append(g.hosts, name)
+}
+
+// setDepth sets the depth of every group to its longest distance from GroupAll.
+func (inv *Inventory) setDepth(name string, depth int, path map[string]bool) error {
+	if path[name] {
+		return fmt.Errorf("%w: group %s is its own child", ErrInventorySyntax, name)
+	}
+	g := inv.groups[name]
+	if depth > g.depth {
+		g.depth = depth
+	}
+	path[name] = true
+	defer delete(path, name)
+	for _, child := range g.children {
+		err := inv.setDepth(child, depth+1, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Groups returns the names of the groups in the order they first appear,
+// after GroupAll and GroupUngrouped.
+func (inv *Inventory) Groups() []string {
+	groups := make([]string, len(inv.order))
+	copy(groups, inv.order)
+	return groups
+}
+
+// groupHosts returns the hosts of a group and of its children, recursively.
+func (inv *Inventory) groupHosts(name string, hosts map[string]bool) {
+	g := inv.groups[name]
+	for _, host := range g.hosts {
+		hosts[host] = true
+	}
+	for _, child := range g.children {
+		inv.groupHosts(child, hosts)
+	}
+}
+
+// hostGroups returns the groups of host, directly or through their children.
+func (inv *Inventory) hostGroups(host string) []string {
+	var groups []string
+	for _, name := range inv.order {
+		hosts := make(map[string]bool)
+		inv.groupHosts(name, hosts)
+		if hosts[host] {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// Hosts returns the hosts selected by pattern, in the order they first appear.
+// Like the host patterns of Ansible, pattern is a list of groups and hosts
+// separated by ":" or ",", which may contain "*" wildcards or start with "~"
+// for a regular expression. "all" and "*" select every host, elements
+// prefixed with "&" intersect the selection and those prefixed with "!"
+// exclude hosts from it.
+//
+//	web*:db:&prod:!web-test
+func (inv *Inventory) Hosts(pattern string) ([]string, error) {
+	var union, intersections, exclusions []string
+	for _, element := range strings.FieldsFunc(pattern, func(r rune) bool {
+		return r == ':' || r == ','
+	}) {
+		element = strings.TrimSpace(element)
+		switch {
+		case strings.HasPrefix(element, "&"):
+			intersections = append(intersections, element[1:])
+		case strings.HasPrefix(element, "!"):
+			exclusions = append(exclusions, element[1:])
+		case element != "":
+			union = append(union, element)
+		}
+	}
+	selected := make(map[string]bool)
+	for _, element := range union {
+		hosts, err := inv.match(element)
+		if err != nil {
+			return nil, err
+		}
+		for host := range hosts {
+			selected[host] = true
+		}
+	}
+	for _, element := range intersections {
+		hosts, err := inv.match(element)
+		if err != nil {
+			return nil, err
+		}
+		for host := range selected {
+			if !hosts[host] {
+				delete(selected, host)
+			}
+		}
+	}
+	for _, element := range exclusions {
+		hosts, err := inv.match(element)
+		if err != nil {
+			return nil, err
+		}
+		for host := range hosts {
+			delete(selected, host)
+		}
+	}
+	result := make([]string, 0, len(selected))
+	for _, host := range inv.hosts {
+		if selected[host] {
+			result = append(result, host)
+		}
+	}
+	return result, nil
+}
+
+// match returns the hosts of the groups and the hosts matching a single
+// element of a pattern.
+func (inv *Inventory) match(element string) (map[string]bool, error) {
+	var matches func(name string) bool
+	switch {
+	case element == "*":
+		element = GroupAll
+		fallthrough
+	case !strings.HasPrefix(element, "~") && !strings.Contains(element, "*"):
+		matches = func(name string) bool {
+			return name == element
+		}
+	case strings.HasPrefix(element, "~"):
+		re, err := regexp.Compile(element[1:])
+		if err != nil {
+			return nil, err
+		}
+		matches = re.MatchString
+	default:
+		matches = func(name string) bool {
+			return matchPattern(element, name)
+		}
+	}
+	hosts := make(map[string]bool)
+	for _, name := range inv.order {
+		if matches(name) {
+			inv.groupHosts(name, hosts)
+		}
+	}
+	for _, host := range inv.hosts {
+		if matches(host) {
+			hosts[host] = true
+		}
+	}
+	return hosts, nil
+}
+
+// Vars returns the variables of host, nil when it is not in the inventory.
+func (inv *Inventory) Vars(host string) map[string]string {
+	hostVars, ok := inv.vars[host]
+	if !ok {
+		return nil
+	}
+	groups := inv.hostGroups(host)
+	sort.SliceStable(groups, func(i, j int) bool {
+		gi, gj := inv.groups[groups[i]], inv.groups[groups[j]]
+		if gi.depth != gj.depth {
+			return gi.depth < gj.depth
+		}
+		return groups[i] < groups[j]
+	})
+	vars := make(map[string]string)
+	for _, group := range groups {
+		for key, value := range inv.groups[group].vars {
+			vars[key] = value
+		}
+	}
+	for key, value := range hostVars {
+		vars[key] = value
+	}
+	return vars
+}
+
+// Config returns the config of host built from its variables:
+//
+//	ansible_host                  the address, the name of the host by default
+//	ansible_port                  the port, 22 by default
+//	ansible_user                  the user
+//	ansible_password              the password, or ansible_ssh_pass
+//	ansible_ssh_private_key_file  an identity file
+//	ansible_become_password       the sudo password, or ansible_become_pass
+//	ansible_connection            "local" to run on this machine, "ssh" by default
+func (inv *Inventory) Config(host string) (Config, error) {
+	vars := inv.Vars(host)
+	if vars == nil {
+		return Config{}, fmt.Errorf("%w: %s", ErrUnknownHost, host)
+	}
+	address := host
+	if value := vars["ansible_host"]; value != "" {
+		address = value
+	}
+	var port uint16 = 22
+	if value := vars["ansible_port"]; value != "" {
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || n == 0 {
+			return Config{}, fmt.Errorf("%s: bad ansible_port %q", host, value)
+		}
+		port = uint16(n)
+	}
+	isLocal := false
+	switch connection := vars["ansible_connection"]; connection {
+	case "", "ssh", "smart", "paramiko":
+	case "local":
+		isLocal = true
+	default:
+		return Config{}, fmt.Errorf("%s: unsupported ansible_connection %q", host, connection)
+	}
+	password := vars["ansible_password"]
+	if password == "" {
+		password = vars["ansible_ssh_pass"]
+	}
+	config := NewConfig(isLocal, address, vars["ansible_user"], password, port)
+	if isLocal {
+		config.AddLocalHost(address)
+	}
+	if value := vars["ansible_ssh_private_key_file"]; value != "" {
+		config.AddIdentityFile(expandHome(value))
+	}
+	if value := vars["ansible_become_password"]; value != "" {
+		config.SetSudoPassword(value)
+	} else if value = vars["ansible_become_pass"]; value != "" {
+		config.SetSudoPassword(value)
+	}
+	return config, nil
+}
+
+// Configs returns the configs of the hosts selected by pattern, see Hosts,
+// e.g. for Executor.Run.
+func (inv *Inventory) Configs(pattern string) ([]Config, error) {
+	hosts, err := inv.Hosts(pattern)
+	if err != nil {
+		return nil, err
+	}
+	configs := make([]Config, 0, len(hosts))
+	for _, host := range hosts {
+		config, err := inv.Config(host)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
@@ -0,0 +1,148 @@
+package xssh
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testInventory = `
+# hosts outside any group
+bastion.example.com ansible_user=jump
+
+[web]
+web[01:03].example.com
+web-test ansible_host=10.0.0.9 ansible_port=2222 ansible_password="a b#c"
+
+[db]
+db-[a:b] ansible_ssh_private_key_file=/keys/db
+
+[prod:children]
+web
+db
+
+[prod:vars]
+ansible_user=deploy
+ansible_become_pass = 'sudo secret'
+
+[web:vars]
+ansible_user=www
+
+[all:vars]
+ansible_user=nobody
+ansible_port=2200
+
+[local]
+localhost ansible_connection=local
+`
+
+func TestInventory_Hosts(t *testing.T) {
+	inv, err := ParseInventory(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups := strings.Join(inv.Groups(), ","); groups != "all,ungrouped,web,db,prod,local" {
+		t.Fatalf("unexpected groups %s", groups)
+	}
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"all", "bastion.example.com,web01.example.com,web02.example.com,web03.example.com,web-test,db-a,db-b,localhost"},
+		{"ungrouped", "bastion.example.com"},
+		{"prod", "web01.example.com,web02.example.com,web03.example.com,web-test,db-a,db-b"},
+		{"db:web-test", "web-test,db-a,db-b"},
+		{"prod:!web-test:!db", "web01.example.com,web02.example.com,web03.example.com"},
+		{"*.example.com:&prod", "web01.example.com,web02.example.com,web03.example.com"},
+		{"~web0[12]", "web01.example.com,web02.example.com"},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		hosts, err := inv.Hosts(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(hosts, ","); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestInventory_Config(t *testing.T) {
+	inv, err := ParseInventory(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	web, err := inv.Config("web-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if web.Host() != "10.0.0.9" || web.Port() != 2222 || web.User() != "www" || web.Password() != "a b#c" ||
+		web.SudoPassword() != "sudo secret" || web.IsLocal() {
+		t.Fatalf("unexpected web config %s@%s:%d %q %q", web.User(), web.Host(), web.Port(), web.Password(), web.SudoPassword())
+	}
+	configs, err := inv.Configs("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 || configs[1].Host() != "db-b" || configs[1].User() != "deploy" || configs[1].Port() != 2200 {
+		t.Fatalf("unexpected db configs %+v", configs)
+	}
+	if auths := configs[0].AuthMethods(); len(auths) != 1 || auths[0].(*keyFileAuth).path != "/keys/db" {
+		t.Fatalf("unexpected auth methods %+v", auths)
+	}
+	local, err := inv.Config("localhost")
+	if err != nil || !local.IsLocal() {
+		t.Fatalf("expected a local config, got %v", err)
+	}
+	_, err = inv.Config("unknown")
+	if !errors.Is(err, ErrUnknownHost) {
+		t.Fatalf("expected %v, got %v", ErrUnknownHost, err)
+	}
+}
+
+func TestInventory_ConfigKeyAndPassword(t *testing.T) {
+	inv, err := ParseInventory(strings.NewReader(`
+[app]
+app1 ansible_password=pw
+
+[app:vars]
+ansible_ssh_private_key_file=/keys/app
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := inv.Config("app1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the inherited key is tried first, then the password of the host
+	auths := config.AuthMethods()
+	if len(auths) != 3 {
+		t.Fatalf("want the key followed by the password methods, got %+v", auths)
+	}
+	if key, ok := auths[0].(*keyFileAuth); !ok || key.path != "/keys/app" {
+		t.Fatalf("unexpected first auth method %+v", auths[0])
+	}
+	if password, ok := auths[1].(*passwordAuth); !ok || password.password != "pw" {
+		t.Fatalf("unexpected second auth method %+v", auths[1])
+	}
+	if _, ok := auths[2].(*keyboardInteractiveAuth); !ok {
+		t.Fatalf("unexpected third auth method %+v", auths[2])
+	}
+}
+
+func TestParseInventory_Errors(t *testing.T) {
+	for name, content := range map[string]string{
+		"section": "[web:hosts]\n",
+		"range":   "[web]\nweb[3:1]\n",
+		"vars":    "[web:vars]\nuser\n",
+		"quote":   "web ansible_password=\"a\n",
+		"cycle":   "[a:children]\nb\n[b:children]\na\n",
+	} {
+		_, err := ParseInventory(strings.NewReader(content))
+		if !errors.Is(err, ErrInventorySyntax) {
+			t.Errorf("%s: expected a syntax error, got %v", name, err)
+		}
+	}
+}
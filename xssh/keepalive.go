@@ -0,0 +1,234 @@
+package xssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"time"
+)
+
+var (
+	ErrConnectionLost = errors.New("ssh connection lost")
+)
+
+// lostGrace is how long a command that failed without an exit status waits
+// for its connection to be reported gone, since both happen at about the same time.
+const lostGrace = 100 * time.Millisecond
+
+// ReconnectPolicy controls how a RemoteSession whose connection was lost
+// reconnects before its next command.
+type ReconnectPolicy struct {
+	// MaxAttempts is the number of connection attempts, zero disables reconnecting.
+	MaxAttempts int
+	// Backoff is the delay after the first failed attempt, doubled after each
+	// further one up to MaxBackoff. They default to 1s and 30s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+type DisconnectHook func(host string, err error)
+
+type ReconnectHook func(host string, attempts int)
+
+// clientDone returns a channel which is closed once the transport of client is gone.
+func clientDone(client *ssh.Client) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// monitor sends keepalive requests on client until stop is closed. The
+// connection is marked as lost when the transport is gone or too many
+// requests are left unanswered, in which case client is closed so that
+// running commands fail instead of hanging. A pooled client may be shared
+// with other sessions, the pool closes it once none of them uses it.
+func (s *RemoteSession) monitor(client *ssh.Client, pooled *pooledConn, done <-chan struct{}, stop <-chan struct{}) {
+	interval := s.Config.KeepAliveInterval()
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	missed := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-done:
+			s.disconnected(client, client.Wait())
+			return
+		case <-tick:
+			if keepAlive(client, interval) {
+				missed = 0
+				continue
+			}
+			missed++
+			if missed < s.Config.KeepAliveMaxMissed() {
+				continue
+			}
+			if pooled != nil {
+				s.pool.lose(pooled)
+			} else {
+				_ = client.Close()
+			}
+			s.disconnected(client, fmt.Errorf("%d keepalive requests unanswered", missed))
+			return
+		}
+	}
+}
+
+// keepAlive reports whether the server answered a keepalive request within
+// timeout. Any answer counts, OpenSSH replies with a failure.
+func keepAlive(client *ssh.Client, timeout time.Duration) bool {
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (s *RemoteSession) disconnected(client *ssh.Client, err error) {
+	s.lock.Lock()
+	if s.Client != client || s.lost != nil {
+		//closed, replaced or already reported in the meantime
+		s.lock.Unlock()
+		return
+	}
+	s.lost = err
+	s.lock.Unlock()
+	for _, hook := range s.Config.DisconnectHooks() {
+		hook(s.Config.Host(), err)
+	}
+}
+
+// ensureConnected returns nil when the session is connected. When the
+// connection was lost, it reconnects as allowed by the reconnect policy.
+func (s *RemoteSession) ensureConnected(ctx context.Context) error {
+	if s.origin != nil {
+		err := s.origin.ensureConnected(ctx)
+		s.follow()
+		return err
+	}
+	//the transport may be gone before the monitor noticed
+	s.lock.Lock()
+	client, done := s.Client, s.done
+	s.lock.Unlock()
+	if client != nil && done != nil {
+		select {
+		case <-done:
+			s.disconnected(client, client.Wait())
+		default:
+		}
+	}
+	attempts, err := s.reconnect(ctx)
+	if attempts > 0 && err == nil {
+		for _, hook := range s.Config.ReconnectHooks() {
+			hook(s.Config.Host(), attempts)
+		}
+	}
+	return err
+}
+
+// follow takes over the connection of the session a sudo view was made from.
+func (s *RemoteSession) follow() {
+	client, sftpClient, done := s.origin.current()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Client = client
+	s.Sftp = sftpClient
+	s.done = done
+}
+
+// reconnect connects again after the connection was lost. Concurrent callers
+// share the outcome of a single reconnect, and the lock is only held while
+// connecting so that the session stays usable during the backoff.
+func (s *RemoteSession) reconnect(ctx context.Context) (int, error) {
+	s.lock.Lock()
+	if wait := s.reconnecting; wait != nil {
+		s.lock.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.lost != nil {
+			return 0, fmt.Errorf("%w: %v", ErrConnectionLost, s.lost)
+		}
+		return 0, nil
+	}
+	if s.lost == nil {
+		defer s.lock.Unlock()
+		if s.Client == nil {
+			return 0, ErrNilSshClient
+		}
+		return 0, nil
+	}
+	policy := s.Config.Reconnect()
+	if policy.MaxAttempts <= 0 {
+		defer s.lock.Unlock()
+		return 0, fmt.Errorf("%w: %v", ErrConnectionLost, s.lost)
+	}
+	reconnecting := make(chan struct{})
+	s.reconnecting = reconnecting
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.reconnecting = nil
+		s.lock.Unlock()
+		close(reconnecting)
+	}()
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		s.lock.Lock()
+		err := s.connect()
+		s.lock.Unlock()
+		if err == nil {
+			return attempt, nil
+		}
+		if attempt >= policy.MaxAttempts {
+			return attempt, fmt.Errorf("%w: reconnect failed after %d attempts: %v", ErrConnectionLost, attempt, err)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, fmt.Errorf("%w: reconnect canceled after %d attempts: %v", ErrConnectionLost, attempt, ctx.Err())
+		}
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// connectionLost turns the error of a command that ended without an exit
+// status into ErrConnectionLost when its connection is gone.
+func connectionLost(err error, done <-chan struct{}) error {
+	if err == nil || done == nil {
+		return err
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return err
+	}
+	select {
+	case <-done:
+		return fmt.Errorf("%w: %v", ErrConnectionLost, err)
+	case <-time.After(lostGrace):
+		return err
+	}
+}
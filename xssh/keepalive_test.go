@@ -0,0 +1,187 @@
+package xssh
+
+import (
+	"context"
+	"errors"
+	"github.com/candbright/util/xssh/sshtest"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemoteSession_Reconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	config := NewConfig(false, "localhost", "root", "secret", uint16(port))
+	session := &RemoteSession{Config: config, lost: errors.New("EOF")}
+	_, err = session.Output("true")
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("expected ErrConnectionLost without reconnect policy, got %v", err)
+	}
+
+	config.SetReconnect(ReconnectPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	session = &RemoteSession{Config: config, lost: errors.New("EOF")}
+	start := time.Now()
+	_, err = session.Output("true")
+	if !errors.Is(err, ErrConnectionLost) || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("expected ErrConnectionLost after 3 attempts, got %v", err)
+	}
+	if time.Since(start) < 3*time.Millisecond {
+		t.Fatal("expected a backoff between attempts")
+	}
+}
+
+func TestRemoteSession_ReconnectContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	config := NewConfig(false, "localhost", "root", "secret", uint16(port))
+	config.SetReconnect(ReconnectPolicy{MaxAttempts: 3, Backoff: time.Hour})
+	session := &RemoteSession{Config: config, lost: errors.New("EOF")}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = session.OutputContext(ctx, "true")
+		}(i)
+	}
+	//the backoff must not hold the session lock
+	time.Sleep(10 * time.Millisecond)
+	session.lock.Lock()
+	session.lock.Unlock()
+	wg.Wait()
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("expected the backoff to stop with the context")
+	}
+	for _, err := range errs {
+		if !errors.Is(err, ErrConnectionLost) && !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the reconnect to be canceled, got %v", err)
+		}
+	}
+}
+
+func TestPool_LoseKeepsNeighbors(t *testing.T) {
+	server, config := startTestServer(t)
+	server.Handle("true", sshtest.Output("", 0))
+	p := NewPool(0)
+	defer p.Close()
+	first, err := p.Get(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.Get(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//what the monitor of first does after missed keepalives
+	p.lose(first.(*RemoteSession).pooled)
+	if err = second.Run("true"); err != nil {
+		t.Fatalf("expected the neighbor to keep its connection, got %v", err)
+	}
+	if p.Len() != 0 {
+		t.Fatal("expected the lost connection to leave the pool")
+	}
+	_ = first.Close()
+	_ = second.Close()
+	waitConnections(t, server, 0)
+}
+
+func TestConnectionLost(t *testing.T) {
+	done := make(chan struct{})
+	cause := errors.New("wait: remote command exited without exit status or exit signal")
+	if err := connectionLost(cause, done); err != cause {
+		t.Fatalf("expected the error unchanged while connected, got %v", err)
+	}
+	close(done)
+	if err := connectionLost(cause, done); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("expected ErrConnectionLost, got %v", err)
+	}
+	if err := connectionLost(nil, done); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+}
+
+func TestRemoteSession_ReconnectConcurrent(t *testing.T) {
+	withFakeSudo(t)
+	server, config := startTestServer(t)
+	server.Handle("echo ok", sshtest.Output("ok\n", 0))
+	config.SetSudoPassword("secret")
+	config.SetReconnect(ReconnectPolicy{MaxAttempts: 10, Backoff: time.Millisecond})
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	file := filepath.Join(t.TempDir(), "file")
+	err = ioutil.WriteFile(file, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//commands, sftp and the sudo view read the connection while it is replaced,
+	//errors of the operations cut off by a drop are expected
+	operations := []func() error{
+		func() error {
+			_, err := session.Output("echo", "ok")
+			return err
+		},
+		func() error {
+			_, err := session.ReadFile(file)
+			return err
+		},
+		func() error {
+			_, err := session.Sudo().(*RemoteSession).Stat(file)
+			return err
+		},
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, operation := range operations {
+		wg.Add(1)
+		go func(operation func() error) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					_ = operation()
+				}
+			}
+		}(operation)
+	}
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		server.CloseConnections()
+	}
+	close(stop)
+	wg.Wait()
+	//the last drop may not have been noticed yet
+	deadline := time.Now().Add(5 * time.Second)
+	for i, operation := range operations {
+		for err = operation(); err != nil; err = operation() {
+			if time.Now().After(deadline) {
+				t.Fatalf("operation %d after the reconnects: %v", i, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
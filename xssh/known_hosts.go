@@ -0,0 +1,219 @@
+package xssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var knownHostsLock = &sync.Mutex{}
+
+type KnownHost struct {
+	Marker  string
+	Hosts   []string
+	Key     ssh.PublicKey
+	Comment string
+	Line    int
+}
+
+func (h KnownHost) Fingerprint() string {
+	return ssh.FingerprintSHA256(h.Key)
+}
+
+type KnownHosts struct {
+	path string
+}
+
+func NewKnownHosts(path string) *KnownHosts {
+	if path == "" {
+		path = DefaultKnownHostsFile()
+	}
+	return &KnownHosts{path: path}
+}
+
+func DefaultKnownHostsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".ssh", "known_hosts")
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+func (k *KnownHosts) Path() string {
+	return k.path
+}
+
+func (k *KnownHosts) List() ([]KnownHost, error) {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+	lines, err := k.readLines()
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]KnownHost, 0)
+	for i, line := range lines {
+		host, ok := parseKnownHost(line)
+		if !ok {
+			continue
+		}
+		host.Line = i + 1
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// Lookup returns the entries matching host, which may be "host" or "host:port".
+func (k *KnownHosts) Lookup(host string) ([]KnownHost, error) {
+	hosts, err := k.List()
+	if err != nil {
+		return nil, err
+	}
+	address := knownHostsAddress(host)
+	matched := make([]KnownHost, 0)
+	for _, h := range hosts {
+		for _, pattern := range h.Hosts {
+			if knownHostMatch(pattern, address) {
+				matched = append(matched, h)
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+func (k *KnownHosts) Add(host string, key ssh.PublicKey) error {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+	err := os.MkdirAll(filepath.Dir(k.path), 0700)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(k.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(knownhosts.Line([]string{host}, key) + "\n")
+	return err
+}
+
+// Remove deletes host from every entry and drops entries left without hosts.
+// It returns the number of entries that were changed.
+func (k *KnownHosts) Remove(host string) (int, error) {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+	lines, err := k.readLines()
+	if err != nil {
+		return 0, err
+	}
+	address := knownHostsAddress(host)
+	removed := 0
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		entry, ok := parseKnownHost(line)
+		if !ok {
+			kept = append(kept, line)
+			continue
+		}
+		hosts := make([]string, 0, len(entry.Hosts))
+		for _, pattern := range entry.Hosts {
+			if !knownHostMatch(pattern, address) {
+				hosts = append(hosts, pattern)
+			}
+		}
+		if len(hosts) == len(entry.Hosts) {
+			kept = append(kept, line)
+			continue
+		}
+		removed++
+		if len(hosts) != 0 {
+			fields := strings.Fields(line)
+			index := 0
+			if strings.HasPrefix(fields[0], "@") {
+				index = 1
+			}
+			fields[index] = strings.Join(hosts, ",")
+			kept = append(kept, strings.Join(fields, " "))
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	data := strings.Join(kept, "\n")
+	if len(kept) != 0 {
+		data += "\n"
+	}
+	return removed, ioutil.WriteFile(k.path, []byte(data), 0600)
+}
+
+func (k *KnownHosts) callback() (ssh.HostKeyCallback, error) {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+	_, err := os.Stat(k.path)
+	if os.IsNotExist(err) {
+		return knownhosts.New(os.DevNull)
+	}
+	return knownhosts.New(k.path)
+}
+
+func (k *KnownHosts) readLines() ([]string, error) {
+	data, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func parseKnownHost(line string) (KnownHost, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return KnownHost{}, false
+	}
+	marker, hosts, key, comment, _, err := ssh.ParseKnownHosts([]byte(trimmed))
+	if err != nil {
+		return KnownHost{}, false
+	}
+	return KnownHost{Marker: marker, Hosts: hosts, Key: key, Comment: comment}, true
+}
+
+func knownHostsAddress(host string) string {
+	return knownhosts.Normalize(host)
+}
+
+func knownHostMatch(pattern string, address string) bool {
+	if strings.HasPrefix(pattern, "|1|") {
+		parts := strings.Split(pattern[3:], "|")
+		if len(parts) != 2 {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+		hash, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(address))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+	return pattern == address
+}
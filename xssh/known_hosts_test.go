@@ -0,0 +1,106 @@
+package xssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKnownHosts(t *testing.T) {
+	knownHosts := NewKnownHosts(filepath.Join(t.TempDir(), ".ssh", "known_hosts"))
+	key := newTestPublicKey(t)
+	if err := knownHosts.Add("10.0.0.1:22", key); err != nil {
+		t.Fatal(err)
+	}
+	if err := knownHosts.Add("10.0.0.2:2222", key); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := knownHosts.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts[0].Hosts[0] != "10.0.0.1" || hosts[1].Hosts[0] != "[10.0.0.2]:2222" {
+		t.Fatalf("unexpected entries %+v", hosts)
+	}
+	matched, err := knownHosts.Lookup("10.0.0.2:2222")
+	if err != nil || len(matched) != 1 {
+		t.Fatalf("lookup: %v %+v", err, matched)
+	}
+	removed, err := knownHosts.Remove("10.0.0.1")
+	if err != nil || removed != 1 {
+		t.Fatalf("remove: %d %v", removed, err)
+	}
+	hosts, _ = knownHosts.List()
+	if len(hosts) != 1 {
+		t.Fatalf("unexpected entries %+v", hosts)
+	}
+}
+
+func TestHostKeyChecker(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	key := newTestPublicKey(t)
+	other := newTestPublicKey(t)
+
+	config := SimpleConfig("10.0.0.1")
+	config.SetKnownHostsFile(filepath.Join(t.TempDir(), "known_hosts"))
+	config.SetHostKeyPolicy(HostKeyStrict)
+	var hostKeyErr *HostKeyError
+	err := newHostKeyChecker(config).Check("10.0.0.1:22", remote, key)
+	if !errors.As(err, &hostKeyErr) || hostKeyErr.Mismatch() {
+		t.Fatalf("want unknown host error, got %v", err)
+	}
+
+	config.SetHostKeyPolicy(HostKeyTrustOnFirstUse)
+	if err = newHostKeyChecker(config).Check("10.0.0.1:22", remote, key); err != nil {
+		t.Fatal(err)
+	}
+	config.SetHostKeyPolicy(HostKeyStrict)
+	if err = newHostKeyChecker(config).Check("10.0.0.1:22", remote, key); err != nil {
+		t.Fatal(err)
+	}
+	err = newHostKeyChecker(config).Check("10.0.0.1:22", remote, other)
+	if !errors.As(err, &hostKeyErr) || !hostKeyErr.Mismatch() {
+		t.Fatalf("want mismatch error, got %v", err)
+	}
+
+	config.SetHostKeyPolicy(HostKeyFingerprint)
+	config.AddFingerprint(ssh.FingerprintSHA256(key))
+	if err = newHostKeyChecker(config).Check("10.0.0.1:22", remote, key); err != nil {
+		t.Fatal(err)
+	}
+	if err = newHostKeyChecker(config).Check("10.0.0.1:22", remote, other); !errors.As(err, &hostKeyErr) {
+		t.Fatalf("want mismatch error, got %v", err)
+	}
+}
+
+func TestRemoteSession_DefaultHostKeyPolicy(t *testing.T) {
+	_, config := startTestServer(t)
+	config.SetKnownHostsFile(filepath.Join(t.TempDir(), "known_hosts"))
+	session := &RemoteSession{Config: config}
+	var hostKeyErr *HostKeyError
+	if err := session.Connect(); !errors.As(err, &hostKeyErr) {
+		t.Fatalf("want an unknown host key to be rejected by default, got %v", err)
+	}
+	config.SetHostKeyPolicy(HostKeyInsecure)
+	session = &RemoteSession{Config: config}
+	if err := session.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	_ = session.Close()
+}
@@ -0,0 +1,499 @@
+package xssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnexpectedCommand = errors.New("unexpected command")
+)
+
+// MockHost is the host of the results of a MockSession.
+const MockHost = "mock"
+
+// MockSession is a Session for testing code which depends on one. Commands
+// answer as scripted with Expect and ExpectRegexp, the file methods and the
+// transfers work on an in-memory filesystem and every call is recorded. It is
+// safe for concurrent use.
+//
+//	session := NewMockSession()
+//	session.Expect("systemctl is-active nginx").Stdout("active\n")
+//	session.SetFile("/etc/nginx/nginx.conf", []byte(conf), 0644)
+//	err := reload(session)
+//	...
+//	if err := session.Verify(); err != nil {
+//		t.Fatal(err)
+//	}
+type MockSession struct {
+	state *mockState
+	sudo  bool
+}
+
+type mockState struct {
+	lock         sync.Mutex
+	expectations []*Expectation
+	calls        []MockCall
+	platform     Platform
+	fs           *mockFS
+}
+
+// MockCall is a method call recorded by a MockSession.
+type MockCall struct {
+	// Method is the name of the Session method, without the Context suffix.
+	Method string
+	// Args holds the arguments of the call, the command line for the methods
+	// running commands and the paths for the file methods.
+	Args []string
+	// Sudo is set for calls through Sudo and commands built with Cmd.Sudo.
+	Sudo bool
+}
+
+func (c MockCall) String() string {
+	s := c.Method + "(" + strings.Join(c.Args, ", ") + ")"
+	if c.Sudo {
+		s = "sudo " + s
+	}
+	return s
+}
+
+// Expectation is a command expected by a MockSession and its outcome, which
+// is configured before the command runs.
+type Expectation struct {
+	command  string
+	pattern  *regexp.Regexp
+	stdout   []byte
+	stderr   []byte
+	exitCode int
+	err      error
+	times    int
+	calls    int
+}
+
+// Stdout sets the output of the command.
+func (e *Expectation) Stdout(stdout string) *Expectation {
+	e.stdout = []byte(stdout)
+	return e
+}
+
+// Stderr sets the error output of the command.
+func (e *Expectation) Stderr(stderr string) *Expectation {
+	e.stderr = []byte(stderr)
+	return e
+}
+
+// ExitCode sets the exit status of the command, which fails with an *ExitError
+// when it is not 0.
+func (e *Expectation) ExitCode(code int) *Expectation {
+	e.exitCode = code
+	return e
+}
+
+// Error makes the command fail to start with err, e.g. to simulate a lost connection.
+func (e *Expectation) Error(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times expects the command exactly n times. By default it is expected at
+// least once and answers any number of times.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+func (e *Expectation) String() string {
+	if e.pattern != nil {
+		return "/" + e.pattern.String() + "/"
+	}
+	return fmt.Sprintf("%q", e.command)
+}
+
+func (e *Expectation) matches(command string) bool {
+	if e.times > 0 && e.calls >= e.times {
+		return false
+	}
+	if e.pattern != nil {
+		return e.pattern.MatchString(command)
+	}
+	return e.command == command
+}
+
+func (e *Expectation) met() bool {
+	if e.times > 0 {
+		return e.calls == e.times
+	}
+	return e.calls > 0
+}
+
+// NewMockSession returns a mock of a Linux host with an empty filesystem.
+func NewMockSession() *MockSession {
+	return &MockSession{state: &mockState{
+		platform: Platform{Family: FamilyLinux, Kernel: "mock", Arch: "x86_64", Hostname: MockHost, Shell: "/bin/sh"},
+		fs:       newMockFS(),
+	}}
+}
+
+// Expect expects a command whose line, as printed by Cmd.String, equals
+// command. Expectations are matched in the order they were added.
+func (s *MockSession) Expect(command string) *Expectation {
+	return s.expect(&Expectation{command: command})
+}
+
+// ExpectRegexp expects the commands whose line matches pattern. It panics when
+// pattern does not compile.
+func (s *MockSession) ExpectRegexp(pattern string) *Expectation {
+	return s.expect(&Expectation{pattern: regexp.MustCompile(pattern)})
+}
+
+func (s *MockSession) expect(e *Expectation) *Expectation {
+	s.state.lock.Lock()
+	defer s.state.lock.Unlock()
+	s.state.expectations = append(s.state.expectations, e)
+	return e
+}
+
+// Verify returns an error listing the expectations which were not met.
+func (s *MockSession) Verify() error {
+	s.state.lock.Lock()
+	defer s.state.lock.Unlock()
+	var unmet []string
+	for _, e := range s.state.expectations {
+		if !e.met() {
+			unmet = append(unmet, fmt.Sprintf("%s ran %d times", e, e.calls))
+		}
+	}
+	if len(unmet) != 0 {
+		return fmt.Errorf("unmet expectations: %s", strings.Join(unmet, "; "))
+	}
+	return nil
+}
+
+// Calls returns the calls made so far, in order.
+func (s *MockSession) Calls() []MockCall {
+	s.state.lock.Lock()
+	defer s.state.lock.Unlock()
+	calls := make([]MockCall, len(s.state.calls))
+	copy(calls, s.state.calls)
+	return calls
+}
+
+// SetPlatform changes the platform reported by Platform.
+func (s *MockSession) SetPlatform(platform Platform) {
+	s.state.lock.Lock()
+	defer s.state.lock.Unlock()
+	s.state.platform = platform
+}
+
+// SetFile writes a file to the filesystem, creating its parent directories,
+// without recording a call.
+func (s *MockSession) SetFile(name string, data []byte, perm os.FileMode) error {
+	return s.state.fs.setFile(name, data, perm)
+}
+
+// File returns the content of a file of the filesystem without recording a call.
+func (s *MockSession) File(name string) ([]byte, bool) {
+	data, err := s.state.fs.readFile(name)
+	return data, err == nil
+}
+
+func (s *MockSession) record(method string, sudo bool, arg ...string) {
+	s.state.lock.Lock()
+	defer s.state.lock.Unlock()
+	s.state.calls = append(s.state.calls, MockCall{Method: method, Args: arg, Sudo: s.sudo || sudo})
+}
+
+func (s *MockSession) IsLocal() bool {
+	return false
+}
+
+func (s *MockSession) Connect() error {
+	s.record("Connect", false)
+	return nil
+}
+
+func (s *MockSession) Close() error {
+	s.record("Close", false)
+	return nil
+}
+
+// Sudo returns a view of the session whose calls are recorded with Sudo set.
+// It shares the expectations and the filesystem of s.
+func (s *MockSession) Sudo() Session {
+	return &MockSession{state: s.state, sudo: true}
+}
+
+func (s *MockSession) Platform() (Platform, error) {
+	s.record("Platform", false)
+	s.state.lock.Lock()
+	defer s.state.lock.Unlock()
+	return s.state.platform, nil
+}
+
+func (s *MockSession) Run(name string, arg ...string) error {
+	return s.RunContext(context.Background(), name, arg...)
+}
+
+func (s *MockSession) Output(name string, arg ...string) ([]byte, error) {
+	return s.OutputContext(context.Background(), name, arg...)
+}
+
+func (s *MockSession) CombinedOutput(name string, arg ...string) ([]byte, error) {
+	return s.CombinedOutputContext(context.Background(), name, arg...)
+}
+
+func (s *MockSession) RunContext(ctx context.Context, name string, arg ...string) error {
+	_, err := s.exec(ctx, "Run", NewCmd(name, arg...), nil, &bytes.Buffer{})
+	return err
+}
+
+func (s *MockSession) OutputContext(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	result, err := s.exec(ctx, "Output", NewCmd(name, arg...), &bytes.Buffer{}, &bytes.Buffer{})
+	if err != nil {
+		return nil, err
+	}
+	return result.Stdout, nil
+}
+
+func (s *MockSession) CombinedOutputContext(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	output := &syncBuffer{}
+	_, err := s.exec(ctx, "CombinedOutput", NewCmd(name, arg...), output, output)
+	if err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+func (s *MockSession) Exec(ctx context.Context, cmd *Cmd) (*Result, error) {
+	return s.exec(ctx, "Exec", cmd, &bytes.Buffer{}, &bytes.Buffer{})
+}
+
+func (s *MockSession) Start(ctx context.Context, cmd *Cmd) (Process, error) {
+	return s.start(ctx, "Start", cmd)
+}
+
+func (s *MockSession) exec(ctx context.Context, method string, cmd *Cmd, stdout io.Writer, stderr io.Writer) (*Result, error) {
+	process, err := s.start(ctx, method, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return waitProcess(process, stdout, stderr)
+}
+
+func (s *MockSession) start(ctx context.Context, method string, cmd *Cmd) (Process, error) {
+	command := cmd.String()
+	s.record(method, cmd.sudo, command)
+	err := cmd.validate()
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	e, err := s.match(command)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.stdin != nil {
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, cmd.stdin)
+		}()
+	}
+	return newMockProcess(cmd, e), nil
+}
+
+// match returns the first expectation of command, counting the call.
+func (s *MockSession) match(command string) (*Expectation, error) {
+	s.state.lock.Lock()
+	defer s.state.lock.Unlock()
+	for _, e := range s.state.expectations {
+		if e.matches(command) {
+			e.calls++
+			if e.err != nil {
+				return nil, e.err
+			}
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnexpectedCommand, command)
+}
+
+// mockProcess replays the outcome of an expectation.
+type mockProcess struct {
+	result *Result
+	stdout io.Reader
+	stderr io.Reader
+}
+
+func newMockProcess(cmd *Cmd, e *Expectation) *mockProcess {
+	result := newResult(MockHost, cmd)
+	result.ExitCode = e.exitCode
+	result.End = result.Start
+	return &mockProcess{
+		result: result,
+		stdout: bytes.NewReader(e.stdout),
+		stderr: bytes.NewReader(e.stderr),
+	}
+}
+
+func (p *mockProcess) Stdout() io.Reader {
+	return p.stdout
+}
+
+func (p *mockProcess) Stderr() io.Reader {
+	return p.stderr
+}
+
+func (p *mockProcess) Wait() (*Result, error) {
+	if !p.result.Success() {
+		return p.result, &ExitError{Result: p.result}
+	}
+	return p.result, nil
+}
+
+func (p *mockProcess) Kill() error {
+	return nil
+}
+
+// Shell answers like a command: opts.Cmd is matched against the expectations,
+// the login shell as an empty command line. The terminal prints the stdout and
+// the stderr of the expectation and ignores its input.
+func (s *MockSession) Shell(ctx context.Context, opts TerminalOptions) (Terminal, error) {
+	command := ""
+	sudo := false
+	if opts.Cmd != nil {
+		command = opts.Cmd.String()
+		sudo = opts.Cmd.sudo
+	}
+	s.record("Shell", sudo, command)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	e, err := s.match(command)
+	if err != nil {
+		return nil, err
+	}
+	cmd := opts.Cmd
+	if cmd == nil {
+		cmd = &Cmd{}
+	}
+	process := newMockProcess(cmd, e)
+	return &mockTerminal{Reader: io.MultiReader(process.stdout, process.stderr), process: process}, nil
+}
+
+type mockTerminal struct {
+	io.Reader
+	process *mockProcess
+}
+
+func (t *mockTerminal) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (t *mockTerminal) Resize(width int, height int) error {
+	return nil
+}
+
+func (t *mockTerminal) Wait() error {
+	_, err := t.process.Wait()
+	return err
+}
+
+func (t *mockTerminal) Close() error {
+	return nil
+}
+
+func (s *MockSession) Exists(path string) (bool, error) {
+	s.record("Exists", false, path)
+	_, err := s.state.fs.Stat(path)
+	return err == nil, nil
+}
+
+func (s *MockSession) ReadFile(fileName string) ([]byte, error) {
+	s.record("ReadFile", false, fileName)
+	return s.state.fs.readFile(fileName)
+}
+
+func (s *MockSession) ReadDir(dir string) ([]FileInfo, error) {
+	s.record("ReadDir", false, dir)
+	return s.state.fs.readDir(dir)
+}
+
+func (s *MockSession) Stat(name string) (FileInfo, error) {
+	s.record("Stat", false, name)
+	return s.state.fs.stat(name)
+}
+
+// Lstat is Stat, the filesystem has no symbolic links.
+func (s *MockSession) Lstat(name string) (FileInfo, error) {
+	s.record("Lstat", false, name)
+	return s.state.fs.stat(name)
+}
+
+func (s *MockSession) MakeDirAll(path string, perm os.FileMode) error {
+	s.record("MakeDirAll", false, path, perm.String())
+	return s.state.fs.MkdirAll(path, perm)
+}
+
+func (s *MockSession) Remove(name string) error {
+	s.record("Remove", false, name)
+	return s.state.fs.remove(name)
+}
+
+func (s *MockSession) RemoveAll(path string) error {
+	s.record("RemoveAll", false, path)
+	return s.state.fs.removeAll(path)
+}
+
+func (s *MockSession) Create(name string) error {
+	s.record("Create", false, name)
+	return s.state.fs.writeFile(name, nil, false, 0644)
+}
+
+func (s *MockSession) WriteString(name string, data string, mode ...string) error {
+	s.record("WriteString", false, append([]string{name, data}, mode...)...)
+	appending := len(mode) == 1 && mode[0] == ">>"
+	return s.state.fs.writeFile(name, []byte(data), appending, 0644)
+}
+
+func (s *MockSession) Upload(ctx context.Context, localPath string, remotePath string, opts TransferOptions) error {
+	s.record("Upload", false, localPath, remotePath)
+	if s.sudo {
+		return ErrSudoTransfer
+	}
+	return transfer(ctx, localFS{}, localPath, s.state.fs, remotePath, opts)
+}
+
+func (s *MockSession) Download(ctx context.Context, remotePath string, localPath string, opts TransferOptions) error {
+	s.record("Download", false, remotePath, localPath)
+	if s.sudo {
+		return ErrSudoTransfer
+	}
+	return transfer(ctx, s.state.fs, remotePath, localFS{}, localPath, opts)
+}
+
+func (s *MockSession) UploadFrom(ctx context.Context, r io.Reader, remotePath string, opts TransferOptions) error {
+	s.record("UploadFrom", false, remotePath)
+	if s.sudo {
+		return ErrSudoTransfer
+	}
+	return upload(ctx, r, s.state.fs, remotePath, opts)
+}
+
+func (s *MockSession) DownloadTo(ctx context.Context, remotePath string, w io.Writer, opts TransferOptions) error {
+	s.record("DownloadTo", false, remotePath)
+	if s.sudo {
+		return ErrSudoTransfer
+	}
+	return download(ctx, s.state.fs, remotePath, w, opts)
+}
+
+var _ Session = &MockSession{}
@@ -0,0 +1,364 @@
+package xssh
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// mockFS is the in-memory filesystem of a MockSession. Names are slash
+// separated, relative ones are resolved from the root.
+type mockFS struct {
+	lock  sync.Mutex
+	files map[string]*mockFile
+}
+
+type mockFile struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newMockFS() *mockFS {
+	return &mockFS{files: map[string]*mockFile{
+		"/": {mode: os.ModeDir | 0755, modTime: time.Now()},
+	}}
+}
+
+func mockPath(name string) string {
+	return path.Join("/", name)
+}
+
+// mockFileInfo describes a file of a mockFS.
+type mockFileInfo struct {
+	name string
+	file mockFile
+}
+
+func (i mockFileInfo) Name() string {
+	return i.name
+}
+
+func (i mockFileInfo) Size() int64 {
+	return int64(len(i.file.data))
+}
+
+func (i mockFileInfo) Mode() os.FileMode {
+	return i.file.mode
+}
+
+func (i mockFileInfo) ModTime() time.Time {
+	return i.file.modTime
+}
+
+func (i mockFileInfo) IsDir() bool {
+	return i.file.mode.IsDir()
+}
+
+func (i mockFileInfo) Sys() interface{} {
+	return nil
+}
+
+// lookup returns the file at the cleaned name p. The caller holds the lock.
+func (fs *mockFS) lookup(op string, p string) (*mockFile, error) {
+	file, ok := fs.files[p]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+	}
+	return file, nil
+}
+
+// parent checks that the parent directory of p exists. The caller holds the lock.
+func (fs *mockFS) parent(op string, p string) error {
+	dir, err := fs.lookup(op, path.Dir(p))
+	if err != nil {
+		return err
+	}
+	if !dir.mode.IsDir() {
+		return &os.PathError{Op: op, Path: p, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+// children returns the names of the entries of the directory p, sorted. The
+// caller holds the lock.
+func (fs *mockFS) children(p string) []string {
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	var names []string
+	for name := range fs.files {
+		if name != p && strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+			names = append(names, name[len(prefix):])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (fs *mockFS) setFile(name string, data []byte, perm os.FileMode) error {
+	p := mockPath(name)
+	err := fs.MkdirAll(path.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if file, ok := fs.files[p]; ok && file.mode.IsDir() {
+		return &os.PathError{Op: "open", Path: p, Err: syscall.EISDIR}
+	}
+	fs.files[p] = &mockFile{data: append([]byte(nil), data...), mode: perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (fs *mockFS) readFile(name string) ([]byte, error) {
+	p := mockPath(name)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	file, err := fs.lookup("open", p)
+	if err != nil {
+		return nil, err
+	}
+	if file.mode.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: p, Err: syscall.EISDIR}
+	}
+	return append([]byte(nil), file.data...), nil
+}
+
+// writeFile creates or truncates name, or appends to it, like
+// os.OpenFile followed by a write.
+func (fs *mockFS) writeFile(name string, data []byte, appending bool, perm os.FileMode) error {
+	p := mockPath(name)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	err := fs.parent("open", p)
+	if err != nil {
+		return err
+	}
+	file, ok := fs.files[p]
+	if !ok {
+		file = &mockFile{mode: perm.Perm()}
+		fs.files[p] = file
+	} else if file.mode.IsDir() {
+		return &os.PathError{Op: "open", Path: p, Err: syscall.EISDIR}
+	}
+	if !appending {
+		file.data = nil
+	}
+	file.data = append(file.data, data...)
+	file.modTime = time.Now()
+	return nil
+}
+
+func (fs *mockFS) remove(name string) error {
+	p := mockPath(name)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	file, err := fs.lookup("remove", p)
+	if err != nil {
+		return err
+	}
+	if p == "/" || file.mode.IsDir() && len(fs.children(p)) != 0 {
+		return &os.PathError{Op: "remove", Path: p, Err: syscall.ENOTEMPTY}
+	}
+	delete(fs.files, p)
+	return nil
+}
+
+func (fs *mockFS) removeAll(name string) error {
+	p := mockPath(name)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for name := range fs.files {
+		if name != "/" && (name == p || strings.HasPrefix(name, prefix)) {
+			delete(fs.files, name)
+		}
+	}
+	return nil
+}
+
+func (fs *mockFS) stat(name string) (FileInfo, error) {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return newFileInfo(name, info), nil
+}
+
+func (fs *mockFS) readDir(dir string) ([]FileInfo, error) {
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, len(infos))
+	for i, info := range infos {
+		files[i] = newFileInfo(path.Join(dir, info.Name()), info)
+	}
+	return files, nil
+}
+
+func (*mockFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (*mockFS) Dir(name string) string {
+	return path.Dir(name)
+}
+
+func (fs *mockFS) Stat(name string) (os.FileInfo, error) {
+	p := mockPath(name)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	file, err := fs.lookup("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	return mockFileInfo{name: path.Base(p), file: *file}, nil
+}
+
+func (fs *mockFS) ReadDir(name string) ([]os.FileInfo, error) {
+	p := mockPath(name)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	dir, err := fs.lookup("open", p)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: syscall.ENOTDIR}
+	}
+	names := fs.children(p)
+	infos := make([]os.FileInfo, len(names))
+	for i, child := range names {
+		infos[i] = mockFileInfo{name: child, file: *fs.files[path.Join(p, child)]}
+	}
+	return infos, nil
+}
+
+func (fs *mockFS) Open(name string) (io.ReadCloser, error) {
+	data, err := fs.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *mockFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	err := fs.writeFile(name, nil, false, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &mockWriter{fs: fs, name: name}, nil
+}
+
+func (fs *mockFS) MkdirAll(name string, perm os.FileMode) error {
+	p := mockPath(name)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	var missing []string
+	for dir := p; ; dir = path.Dir(dir) {
+		file, ok := fs.files[dir]
+		if ok {
+			if !file.mode.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+			}
+			break
+		}
+		missing = append(missing, dir)
+	}
+	for _, dir := range missing {
+		fs.files[dir] = &mockFile{mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+	}
+	return nil
+}
+
+func (fs *mockFS) Chmod(name string, mode os.FileMode) error {
+	p := mockPath(name)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	file, err := fs.lookup("chmod", p)
+	if err != nil {
+		return err
+	}
+	file.mode = file.mode&os.ModeType | mode.Perm()
+	return nil
+}
+
+func (fs *mockFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	p := mockPath(name)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	file, err := fs.lookup("chtimes", p)
+	if err != nil {
+		return err
+	}
+	file.modTime = mtime
+	return nil
+}
+
+func (fs *mockFS) Append(name string) (io.WriteCloser, error) {
+	_, err := fs.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &mockWriter{fs: fs, name: name}, nil
+}
+
+func (fs *mockFS) Rename(oldname string, newname string) error {
+	oldPath, newPath := mockPath(oldname), mockPath(newname)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	file, err := fs.lookup("rename", oldPath)
+	if err != nil {
+		return err
+	}
+	if file.mode.IsDir() {
+		return &os.PathError{Op: "rename", Path: oldPath, Err: syscall.EISDIR}
+	}
+	err = fs.parent("rename", newPath)
+	if err != nil {
+		return err
+	}
+	if target, ok := fs.files[newPath]; ok && target.mode.IsDir() {
+		return &os.PathError{Op: "rename", Path: newPath, Err: syscall.EISDIR}
+	}
+	delete(fs.files, oldPath)
+	fs.files[newPath] = file
+	return nil
+}
+
+func (fs *mockFS) Sum(name string, n int64) (string, error) {
+	data, err := fs.readFile(name)
+	if err != nil {
+		return "", err
+	}
+	return sumReader(bytes.NewReader(data), n)
+}
+
+// mockWriter appends to a file of a mockFS.
+type mockWriter struct {
+	fs   *mockFS
+	name string
+}
+
+func (w *mockWriter) Write(p []byte) (int, error) {
+	w.fs.lock.Lock()
+	defer w.fs.lock.Unlock()
+	file, err := w.fs.lookup("write", mockPath(w.name))
+	if err != nil {
+		return 0, err
+	}
+	file.data = append(file.data, p...)
+	file.modTime = time.Now()
+	return len(p), nil
+}
+
+func (w *mockWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package xssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMockSession_Exec(t *testing.T) {
+	session := NewMockSession()
+	session.Expect("systemctl is-active nginx").Stdout("active\n").Times(1)
+	session.ExpectRegexp(`^rm -rf '?/tmp/`).Stderr("permission denied\n").ExitCode(1)
+	session.Expect("reboot").Error(ErrConnectionLost)
+	session.Expect("never")
+	output, err := session.Output("systemctl", "is-active", "nginx")
+	if err != nil || string(output) != "active\n" {
+		t.Fatalf("unexpected output %q %v", output, err)
+	}
+	_, err = session.Output("systemctl", "is-active", "nginx")
+	if !errors.Is(err, ErrUnexpectedCommand) {
+		t.Fatalf("expected the second call to be unexpected, got %v", err)
+	}
+	_, err = session.Sudo().Exec(context.Background(), NewCmd("rm", "-rf", "/tmp/a b"))
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 1 || string(exitErr.Stderr) != "permission denied\n" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = session.Run("reboot")
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var lines []string
+	session.Expect("journalctl").Stdout("a\nb\n")
+	_, err = ExecLines(context.Background(), session, NewCmd("journalctl"), func(stream Stream, line string) {
+		lines = append(lines, line)
+	})
+	if err != nil || strings.Join(lines, ",") != "a,b" {
+		t.Fatalf("unexpected lines %q %v", lines, err)
+	}
+	err = session.Verify()
+	if err == nil || !strings.Contains(err.Error(), `"never" ran 0 times`) || strings.Contains(err.Error(), "nginx") {
+		t.Fatalf("unexpected verification %v", err)
+	}
+	calls := session.Calls()
+	if len(calls) != 5 || calls[2].String() != "sudo Exec(rm -rf '/tmp/a b')" || calls[0].Method != "Output" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+func TestMockSession_Files(t *testing.T) {
+	session := NewMockSession()
+	err := session.SetFile("/etc/app/app.conf", []byte("a=1\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.WriteString("/etc/app/app.conf", "b=2\n", ">>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := session.ReadFile("/etc/app/app.conf")
+	if err != nil || string(data) != "a=1\nb=2\n" {
+		t.Fatalf("unexpected content %q %v", data, err)
+	}
+	err = session.WriteString("/missing/file", "data")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected the missing directory to fail, got %v", err)
+	}
+	err = session.MakeDirAll("/var/lib/app", 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Create("/var/lib/app/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err := session.ReadDir("/var/lib")
+	if err != nil || len(infos) != 1 || !infos[0].IsDir() || infos[0].Mode().Perm() != 0750 || infos[0].Path() != "/var/lib/app" {
+		t.Fatalf("unexpected entries %+v %v", infos, err)
+	}
+	err = session.Remove("/var/lib/app")
+	if err == nil {
+		t.Fatal("expected removing a non-empty directory to fail")
+	}
+	err = session.RemoveAll("/var/lib/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := session.Exists("/var/lib/app/state")
+	if err != nil || exists {
+		t.Fatalf("expected the tree to be removed, got %v %v", exists, err)
+	}
+	ctx := context.Background()
+	err = session.UploadFrom(ctx, strings.NewReader("payload"), "/srv/data", TransferOptions{Verify: true, Mode: 0640})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := session.Stat("/srv/data")
+	if err != nil || info.Size() != 7 || info.Mode() != 0640 {
+		t.Fatalf("unexpected description %+v %v", info, err)
+	}
+	dir := t.TempDir()
+	err = session.Download(ctx, "/etc/app", filepath.Join(dir, "app"), TransferOptions{Preserve: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := os.Stat(filepath.Join(dir, "app", "app.conf"))
+	if err != nil || local.Size() != 8 || local.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected downloaded file %v", err)
+	}
+	var buf bytes.Buffer
+	err = session.DownloadTo(ctx, "/etc/app/app.conf", &buf, TransferOptions{})
+	if err != nil || buf.String() != "a=1\nb=2\n" {
+		t.Fatalf("unexpected download %q %v", buf.String(), err)
+	}
+	err = session.Sudo().UploadFrom(ctx, strings.NewReader("payload"), "/srv/data", TransferOptions{})
+	if !errors.Is(err, ErrSudoTransfer) {
+		t.Fatalf("expected %v, got %v", ErrSudoTransfer, err)
+	}
+}
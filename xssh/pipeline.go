@@ -0,0 +1,128 @@
+package xssh
+
+// Pipeline builds a compound POSIX shell command: commands connected with
+// pipes and the list operators &&, || and ;, redirections and subshells. Every
+// argument and file name is quoted. Run it with Session.Exec or Session.Start
+// through Cmd, which uses sh on local and remote sessions alike. Local Windows
+// sessions have no POSIX shell, they fail with ErrUnsupportedPlatform.
+//
+//	p := NewPipeline("ps", "aux").Pipe("grep", name).Pipe("wc", "-l")
+//	result, err := session.Exec(ctx, p.Cmd())
+type Pipeline struct {
+	args []cmdArg
+}
+
+func NewPipeline(name string, arg ...string) *Pipeline {
+	return (&Pipeline{}).command(name, arg)
+}
+
+// Subshell returns a pipeline running p in a subshell, e.g. to redirect the
+// output of several commands at once or to group operators.
+func Subshell(p *Pipeline) *Pipeline {
+	return (&Pipeline{}).group(p)
+}
+
+func (p *Pipeline) command(name string, arg []string) *Pipeline {
+	p.args = append(p.args, cmdArg{value: name})
+	for _, a := range arg {
+		p.args = append(p.args, cmdArg{value: a})
+	}
+	return p
+}
+
+func (p *Pipeline) group(q *Pipeline) *Pipeline {
+	p.raw("(")
+	p.args = append(p.args, q.args...)
+	return p.raw(")")
+}
+
+func (p *Pipeline) raw(fragment ...string) *Pipeline {
+	for _, f := range fragment {
+		p.args = append(p.args, cmdArg{value: f, raw: true})
+	}
+	return p
+}
+
+func (p *Pipeline) file(operator string, name string) *Pipeline {
+	p.raw(operator)
+	p.args = append(p.args, cmdArg{value: name})
+	return p
+}
+
+// Pipe connects the output of the pipeline to the input of a command, "|".
+func (p *Pipeline) Pipe(name string, arg ...string) *Pipeline {
+	return p.raw("|").command(name, arg)
+}
+
+// And runs a command when the pipeline succeeded, "&&".
+func (p *Pipeline) And(name string, arg ...string) *Pipeline {
+	return p.raw("&&").command(name, arg)
+}
+
+// Or runs a command when the pipeline failed, "||".
+func (p *Pipeline) Or(name string, arg ...string) *Pipeline {
+	return p.raw("||").command(name, arg)
+}
+
+// Then runs a command after the pipeline, ";".
+func (p *Pipeline) Then(name string, arg ...string) *Pipeline {
+	return p.raw(";").command(name, arg)
+}
+
+// PipeSubshell is Pipe with q run in a subshell.
+func (p *Pipeline) PipeSubshell(q *Pipeline) *Pipeline {
+	return p.raw("|").group(q)
+}
+
+// AndSubshell is And with q run in a subshell.
+func (p *Pipeline) AndSubshell(q *Pipeline) *Pipeline {
+	return p.raw("&&").group(q)
+}
+
+// OrSubshell is Or with q run in a subshell.
+func (p *Pipeline) OrSubshell(q *Pipeline) *Pipeline {
+	return p.raw("||").group(q)
+}
+
+// ThenSubshell is Then with q run in a subshell.
+func (p *Pipeline) ThenSubshell(q *Pipeline) *Pipeline {
+	return p.raw(";").group(q)
+}
+
+// RedirectTo redirects the output of the last command to name, "> name".
+func (p *Pipeline) RedirectTo(name string) *Pipeline {
+	return p.file(">", name)
+}
+
+// AppendTo appends the output of the last command to name, ">> name".
+func (p *Pipeline) AppendTo(name string) *Pipeline {
+	return p.file(">>", name)
+}
+
+// InputFrom feeds name to the input of the last command, "< name".
+func (p *Pipeline) InputFrom(name string) *Pipeline {
+	return p.file("<", name)
+}
+
+// StderrTo redirects the error output of the last command to name, "2> name".
+func (p *Pipeline) StderrTo(name string) *Pipeline {
+	return p.file("2>", name)
+}
+
+// CombineOutput sends the error output of the last command to its output,
+// "2>&1". It must come after the redirections of the output.
+func (p *Pipeline) CombineOutput() *Pipeline {
+	return p.raw("2>&1")
+}
+
+// Cmd returns a command running the pipeline. Later changes of the pipeline
+// do not affect it.
+func (p *Pipeline) Cmd() *Cmd {
+	args := make([]cmdArg, len(p.args))
+	copy(args, p.args)
+	return &Cmd{args: args, posix: true}
+}
+
+func (p *Pipeline) String() string {
+	return p.Cmd().String()
+}
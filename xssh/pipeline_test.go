@@ -0,0 +1,86 @@
+package xssh
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPipeline_String(t *testing.T) {
+	p := NewPipeline("grep", "-r", "a b", "/tmp").Pipe("wc", "-l").RedirectTo("out file").CombineOutput().
+		OrSubshell(NewPipeline("echo", "it's").And("false")).Then("cat").InputFrom("$in")
+	want := `grep -r 'a b' /tmp | wc -l > 'out file' 2>&1 || ( echo 'it'\''s' && false ) ; cat < '$in'`
+	if got := p.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	cmd := p.Cmd()
+	p.Pipe("sort")
+	if cmd.String() != want {
+		t.Errorf("the command changed with the pipeline: %s", cmd)
+	}
+}
+
+func TestPipeline_Exec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in put")
+	err := ioutil.WriteFile(input, []byte(strings.Join(hostileArgs, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "$(out)")
+	p := Subshell(NewPipeline("grep", "-v", "-F", "-e", "a; touch /tmp/pwned").InputFrom(input).Pipe("wc", "-l")).
+		RedirectTo(output).
+		And("sh", "-c", "echo error >&2").StderrTo(filepath.Join(dir, "err")).
+		And("false").
+		Or("cat", output)
+	remote := &RemoteSession{Config: startExecServer(t)}
+	err = remote.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	for _, session := range []Session{&LocalSession{}, remote} {
+		result, err := session.Exec(context.Background(), p.Cmd())
+		if err != nil {
+			t.Fatal(err)
+		}
+		//one of the arguments spans two lines and one is filtered out
+		want := strconv.Itoa(len(hostileArgs))
+		if got := strings.TrimSpace(string(result.Stdout)); got != want {
+			t.Errorf("%T: got %q, want %s", session, got, want)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "err"))
+		if err != nil || string(data) != "error\n" {
+			t.Errorf("%T: unexpected error output %q %v", session, data, err)
+		}
+	}
+}
+
+func TestPipeline_LocalWindows(t *testing.T) {
+	cmd := NewPipeline("echo", "a").Pipe("sort").Cmd().Dir("/tmp")
+	err := cmd.validateLocal(true)
+	if !errors.Is(err, ErrUnsupportedPlatform) {
+		t.Fatalf("want %v, got %v", ErrUnsupportedPlatform, err)
+	}
+	if err = cmd.validateLocal(false); err != nil {
+		t.Fatal(err)
+	}
+	//plain commands still run with the command prompt
+	if err = NewCmd("dir", "/b").validateLocal(true); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "windows" {
+		_, err = (&LocalSession{}).Exec(context.Background(), cmd)
+		if !errors.Is(err, ErrUnsupportedPlatform) {
+			t.Fatalf("want %v, got %v", ErrUnsupportedPlatform, err)
+		}
+	}
+}
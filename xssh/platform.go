@@ -0,0 +1,186 @@
+package xssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownPlatform = errors.New("unknown platform")
+)
+
+// OSFamily is the lower case name of an operating system, e.g. as printed by "uname -s".
+type OSFamily string
+
+const (
+	FamilyLinux   OSFamily = "linux"
+	FamilyDarwin  OSFamily = "darwin"
+	FamilyWindows OSFamily = "windows"
+)
+
+// Platform describes the operating system of a session. Facts which could not
+// be found out are empty.
+type Platform struct {
+	Family OSFamily
+	// Distribution and Version are ID and VERSION_ID of /etc/os-release, e.g. "ubuntu" and "22.04".
+	Distribution string
+	Version      string
+	Kernel       string
+	// Arch is the machine hardware name, e.g. "x86_64" or "aarch64".
+	Arch     string
+	Hostname string
+	// Shell is the login shell of the user.
+	Shell string
+}
+
+func (p Platform) IsLinux() bool {
+	return p.Family == FamilyLinux
+}
+
+func (p Platform) IsWindows() bool {
+	return p.Family == FamilyWindows
+}
+
+// bsd returns whether the platform comes with the BSD userland, e.g. its stat(1).
+func (p Platform) bsd() bool {
+	switch p.Family {
+	case FamilyDarwin, "freebsd", "openbsd", "netbsd", "dragonfly":
+		return true
+	default:
+		return false
+	}
+}
+
+// posixProbe prints the platform facts as key=value lines, followed by /etc/os-release.
+const posixProbe = `echo "os=$(uname -s)"; echo "kernel=$(uname -r)"; echo "arch=$(uname -m)"; ` +
+	`echo "hostname=$(uname -n)"; echo "shell=$SHELL"; cat /etc/os-release 2>/dev/null || true`
+
+const windowsProbe = `ver & hostname & echo %PROCESSOR_ARCHITECTURE% & echo %COMSPEC%`
+
+var windowsVersion = regexp.MustCompile(`\[Version ([0-9.]+)\]`)
+
+// probePlatform finds out the platform with POSIX commands, falling back to
+// the Windows command prompt.
+func probePlatform(exec execFunc) (Platform, error) {
+	output := &bytes.Buffer{}
+	_, err := exec(context.Background(), (&Cmd{}).Raw(posixProbe), output, nil)
+	if err == nil {
+		platform, ok := parsePosixProbe(output.String())
+		if ok {
+			return platform, nil
+		}
+	}
+	output.Reset()
+	_, windowsErr := exec(context.Background(), (&Cmd{}).Raw(windowsProbe), output, nil)
+	if windowsErr == nil {
+		platform, ok := parseWindowsProbe(output.String())
+		if ok {
+			return platform, nil
+		}
+	}
+	if err != nil {
+		return Platform{}, err
+	}
+	return Platform{}, ErrUnknownPlatform
+}
+
+func parsePosixProbe(output string) (Platform, bool) {
+	values := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) == 2 {
+			values[kv[0]] = strings.Trim(kv[1], `"'`)
+		}
+	}
+	if values["os"] == "" {
+		return Platform{}, false
+	}
+	return Platform{
+		Family:       OSFamily(strings.ToLower(values["os"])),
+		Distribution: values["ID"],
+		Version:      values["VERSION_ID"],
+		Kernel:       values["kernel"],
+		Arch:         values["arch"],
+		Hostname:     values["hostname"],
+		Shell:        values["shell"],
+	}, true
+}
+
+func parseWindowsProbe(output string) (Platform, bool) {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 4 || !strings.Contains(lines[0], "Windows") {
+		return Platform{}, false
+	}
+	platform := Platform{Family: FamilyWindows, Hostname: lines[1], Arch: lines[2], Shell: lines[3]}
+	match := windowsVersion.FindStringSubmatch(lines[0])
+	if match != nil {
+		platform.Version = match[1]
+		platform.Kernel = match[1]
+	}
+	return platform, true
+}
+
+var (
+	localPlatform     *Platform
+	localPlatformLock sync.Mutex
+)
+
+// Platform returns the platform of the local host, found out once per process.
+// A failed probe is tried again the next time.
+func (s *LocalSession) Platform() (Platform, error) {
+	localPlatformLock.Lock()
+	defer localPlatformLock.Unlock()
+	if localPlatform != nil {
+		return *localPlatform, nil
+	}
+	platform := Platform{Family: FamilyWindows, Arch: runtime.GOARCH, Shell: os.Getenv("COMSPEC")}
+	if !s.windows() {
+		//probe without sudo, the facts are about the user
+		session := &LocalSession{Config: s.Config}
+		probed, err := probePlatform(session.exec)
+		if err != nil {
+			return Platform{}, err
+		}
+		platform = probed
+	} else {
+		platform.Hostname, _ = os.Hostname()
+	}
+	localPlatform = &platform
+	return platform, nil
+}
+
+// Platform returns the platform of the remote host. It is found out once per
+// connection, the first time it is needed.
+func (s *RemoteSession) Platform() (Platform, error) {
+	if s.origin != nil {
+		return s.origin.Platform()
+	}
+	s.lock.Lock()
+	platform := s.platform
+	s.lock.Unlock()
+	if platform != nil {
+		return *platform, nil
+	}
+	probed, err := probePlatform(s.exec)
+	if err != nil {
+		return Platform{}, err
+	}
+	s.lock.Lock()
+	if s.platform == nil {
+		s.platform = &probed
+	}
+	s.lock.Unlock()
+	return probed, nil
+}
@@ -0,0 +1,111 @@
+package xssh
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestProbePlatform(t *testing.T) {
+	posix := "os=Linux\nkernel=5.15.0-91-generic\narch=x86_64\nhostname=web1\nshell=/bin/bash\n" +
+		"NAME=\"Ubuntu\"\nID=ubuntu\nVERSION_ID=\"22.04\"\n"
+	windows := "\r\nMicrosoft Windows [Version 10.0.19045.3803]\r\nWIN1\r\nAMD64\r\nC:\\Windows\\system32\\cmd.exe\r\n"
+	tests := []struct {
+		name   string
+		output func(script string) string
+		want   Platform
+	}{
+		{
+			name: "linux",
+			output: func(script string) string {
+				return posix
+			},
+			want: Platform{Family: FamilyLinux, Distribution: "ubuntu", Version: "22.04", Kernel: "5.15.0-91-generic",
+				Arch: "x86_64", Hostname: "web1", Shell: "/bin/bash"},
+		},
+		{
+			name: "windows",
+			output: func(script string) string {
+				if script == posixProbe {
+					//cmd echoes the script
+					return `"os=$(uname -s)"; echo "kernel=$(uname -r)"`
+				}
+				return windows
+			},
+			want: Platform{Family: FamilyWindows, Version: "10.0.19045.3803", Kernel: "10.0.19045.3803",
+				Arch: "AMD64", Hostname: "WIN1", Shell: `C:\Windows\system32\cmd.exe`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := func(ctx context.Context, cmd *Cmd, stdout io.Writer, stderr io.Writer) (*Result, error) {
+				_, _ = io.WriteString(stdout, tt.output(cmd.String()))
+				return &Result{}, nil
+			}
+			got, err := probePlatform(exec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteSession_Platform(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the test server runs commands with sh")
+	}
+	local, err := (&LocalSession{}).Platform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !local.IsLinux() || local.Kernel == "" || local.Arch == "" || local.Hostname == "" {
+		t.Fatalf("unexpected local platform %+v", local)
+	}
+	session := &RemoteSession{Config: startExecServer(t)}
+	err = session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	remote, err := session.Platform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the test server runs on this host
+	if remote != local {
+		t.Fatalf("remote platform %+v differs from local %+v", remote, local)
+	}
+	if session.platform == nil || !session.IsLinux() {
+		t.Fatal("expected the platform to be cached")
+	}
+}
+
+func TestLocalSession_PlatformRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the platform of Windows is not probed")
+	}
+	localPlatformLock.Lock()
+	localPlatform = nil
+	localPlatformLock.Unlock()
+	path := os.Getenv("PATH")
+	_ = os.Setenv("PATH", t.TempDir())
+	defer os.Setenv("PATH", path)
+	//without sh the probe fails, which must not stick
+	_, err := (&LocalSession{}).Platform()
+	if err == nil {
+		t.Fatal("expected the probe to fail without sh")
+	}
+	_ = os.Setenv("PATH", path)
+	platform, err := (&LocalSession{}).Platform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if platform.Family != OSFamily(runtime.GOOS) || platform.Kernel == "" {
+		t.Fatalf("unexpected local platform %+v", platform)
+	}
+}
@@ -0,0 +1,295 @@
+package xssh
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPoolClosed = errors.New("session pool is closed")
+)
+
+// Pool shares one ssh connection per (host, port, user) between the sessions
+// it hands out, as long as their configs also agree on the credentials, the
+// host key checks and the jump hosts. A connection is dialled with the config
+// of the first session asking for it, and closed once it has been idle, i.e.
+// without open sessions, for longer than the idle timeout.
+type Pool struct {
+	idleTimeout time.Duration
+	lock        sync.Mutex
+	conns       map[poolKey]*pooledConn
+	closed      bool
+	stop        chan struct{}
+}
+
+// poolKey tells configs sharing a connection apart. The credentials are only
+// kept as a digest.
+type poolKey struct {
+	host    string
+	port    uint16
+	user    string
+	auth    [sha256.Size]byte
+	hostKey string
+	jumps   [sha256.Size]byte
+}
+
+func newPoolKey(config Config) poolKey {
+	auth := sha256.New()
+	for _, method := range config.auths {
+		writeField(auth, authID(method))
+	}
+	if config.Password() != "" {
+		writeField(auth, "password "+config.Password())
+	}
+	jumps := sha256.New()
+	for _, jump := range config.jumpHosts {
+		newPoolKey(jump).write(jumps)
+	}
+	key := poolKey{
+		host:    config.Host(),
+		port:    config.Port(),
+		user:    config.User(),
+		hostKey: fmt.Sprintf("%s %s %s", config.HostKeyPolicy(), config.KnownHostsFile(), strings.Join(config.Fingerprints(), ",")),
+	}
+	copy(key.auth[:], auth.Sum(nil))
+	copy(key.jumps[:], jumps.Sum(nil))
+	return key
+}
+
+// write adds every field of k to h.
+func (k poolKey) write(h hash.Hash) {
+	writeField(h, k.host)
+	writeField(h, strconv.Itoa(int(k.port)))
+	writeField(h, k.user)
+	_, _ = h.Write(k.auth[:])
+	writeField(h, k.hostKey)
+	_, _ = h.Write(k.jumps[:])
+}
+
+// writeField adds value to h prefixed by its length, so that consecutive
+// fields cannot be confused.
+func writeField(h hash.Hash, value string) {
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(len(value)))
+	_, _ = h.Write(length)
+	_, _ = h.Write([]byte(value))
+}
+
+// authID identifies an auth method by its settings.
+func authID(auth AuthMethod) string {
+	switch a := auth.(type) {
+	case *passwordAuth:
+		return "password " + a.password
+	case *keyFileAuth:
+		return "key " + a.path + " " + a.certPath + " " + a.passphrase
+	case *agentAuth:
+		return "agent " + a.socket
+	default:
+		//a challenge callback can only be told apart by identity
+		return fmt.Sprintf("%T %p", auth, auth)
+	}
+}
+
+type pooledConn struct {
+	key      poolKey
+	ready    chan struct{}
+	err      error
+	client   *ssh.Client
+	jumps    []*ssh.Client
+	sftp     *sftp.Client
+	done     chan struct{}
+	refs     int
+	lastUsed time.Time
+	lost     bool
+}
+
+// NewPool returns a pool which closes connections idle for idleTimeout. Zero keeps them open until Close.
+func NewPool(idleTimeout time.Duration) *Pool {
+	p := &Pool{
+		idleTimeout: idleTimeout,
+		conns:       make(map[poolKey]*pooledConn),
+		stop:        make(chan struct{}),
+	}
+	if idleTimeout > 0 {
+		go p.reap()
+	}
+	return p
+}
+
+// Get returns a connected session for config. Closing the session hands its
+// connection back to the pool. Local configs get a plain LocalSession.
+func (p *Pool) Get(config Config) (Session, error) {
+	if config.IsLocal() {
+		return &LocalSession{Config: config}, nil
+	}
+	session := &RemoteSession{Config: config, pool: p}
+	err := session.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Len returns the number of open connections, idle or not.
+func (p *Pool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.conns)
+}
+
+// Close closes every connection, including the ones of sessions still in use.
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+	conns := p.conns
+	p.conns = make(map[poolKey]*pooledConn)
+	p.lock.Unlock()
+	var err error
+	for _, conn := range conns {
+		<-conn.ready
+		if conn.err == nil {
+			closeErr := closeClient(conn.sftp, conn.client, conn.jumps)
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+func (p *Pool) attach(s *RemoteSession) error {
+	conn, err := p.acquire(s.Config)
+	if err != nil {
+		return err
+	}
+	s.Client = conn.client
+	s.Sftp = conn.sftp
+	s.pooled = conn
+	return nil
+}
+
+func (p *Pool) acquire(config Config) (*pooledConn, error) {
+	key := newPoolKey(config)
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil, ErrPoolClosed
+	}
+	conn, ok := p.conns[key]
+	if ok {
+		conn.refs++
+		p.lock.Unlock()
+		<-conn.ready
+		if conn.err != nil {
+			p.release(conn)
+			return nil, conn.err
+		}
+		return conn, nil
+	}
+	conn = &pooledConn{key: key, ready: make(chan struct{}), refs: 1}
+	p.conns[key] = conn
+	p.lock.Unlock()
+
+	//dial without holding the lock so that other hosts are not blocked
+	conn.client, conn.jumps, conn.err = dialChain(config)
+	if conn.err == nil {
+		conn.sftp = newSftpClient(conn.client)
+		conn.done = clientDone(conn.client)
+	}
+	close(conn.ready)
+	if conn.err != nil {
+		p.remove(key, conn)
+		return nil, conn.err
+	}
+	go func() {
+		//forget the connection as soon as the transport is gone
+		<-conn.done
+		if p.remove(key, conn) {
+			_ = closeClient(conn.sftp, conn.client, conn.jumps)
+		}
+	}()
+	return conn, nil
+}
+
+func (p *Pool) release(conn *pooledConn) {
+	p.lock.Lock()
+	conn.refs--
+	conn.lastUsed = time.Now()
+	unused := conn.lost && conn.refs == 0
+	p.lock.Unlock()
+	if unused {
+		_ = closeClient(conn.sftp, conn.client, conn.jumps)
+	}
+}
+
+// lose removes a connection which stopped answering, so that the next
+// sessions dial a new one. It is closed once the sessions sharing it released
+// it, each of them noticing the loss on its own.
+func (p *Pool) lose(conn *pooledConn) {
+	p.remove(conn.key, conn)
+	p.lock.Lock()
+	conn.lost = true
+	unused := conn.refs == 0
+	p.lock.Unlock()
+	if unused {
+		_ = closeClient(conn.sftp, conn.client, conn.jumps)
+	}
+}
+
+// remove deletes conn from the pool and reports whether it was still in it.
+func (p *Pool) remove(key poolKey, conn *pooledConn) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conns[key] != conn {
+		return false
+	}
+	delete(p.conns, key)
+	return true
+}
+
+func (p *Pool) reap() {
+	interval := p.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			for _, conn := range p.idle(now) {
+				_ = closeClient(conn.sftp, conn.client, conn.jumps)
+			}
+		}
+	}
+}
+
+// idle removes and returns the connections which have been idle for longer than the idle timeout.
+func (p *Pool) idle(now time.Time) []*pooledConn {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	conns := make([]*pooledConn, 0)
+	for key, conn := range p.conns {
+		if conn.refs == 0 && now.Sub(conn.lastUsed) >= p.idleTimeout {
+			delete(p.conns, key)
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
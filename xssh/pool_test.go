@@ -0,0 +1,144 @@
+package xssh
+
+import (
+	"fmt"
+	"github.com/candbright/util/xssh/sshtest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitConnections waits for the test server to have n open connections.
+func waitConnections(t *testing.T, server *sshtest.Server, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for server.Connections() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d server connections, got %d", n, server.Connections())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPool_Idle(t *testing.T) {
+	p := NewPool(time.Minute)
+	defer p.Close()
+	now := time.Now()
+	ready := make(chan struct{})
+	close(ready)
+	p.conns[poolKey{host: "busy"}] = &pooledConn{ready: ready, refs: 1, lastUsed: now.Add(-time.Hour)}
+	p.conns[poolKey{host: "recent"}] = &pooledConn{ready: ready, lastUsed: now.Add(-time.Second)}
+	p.conns[poolKey{host: "idle"}] = &pooledConn{ready: ready, lastUsed: now.Add(-2 * time.Minute)}
+	idle := p.idle(now)
+	if len(idle) != 1 || p.Len() != 2 {
+		t.Fatalf("expected 1 idle connection and 2 left, got %d and %d", len(idle), p.Len())
+	}
+	if _, ok := p.conns[poolKey{host: "idle"}]; ok {
+		t.Fatal("idle connection was not removed")
+	}
+}
+
+func TestPool_Closed(t *testing.T) {
+	p := NewPool(0)
+	session, err := p.Get(SimpleConfig("127.0.0.1"))
+	if err != nil || !session.IsLocal() {
+		t.Fatalf("expected a local session, got %v %v", session, err)
+	}
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Get(SimpleConfig("10.0.0.1"))
+	if err != ErrPoolClosed {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestPool_Share(t *testing.T) {
+	server, config := startTestServer(t)
+	p := NewPool(0)
+	defer p.Close()
+	first, err := p.Get(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.Get(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.(*RemoteSession).Client != second.(*RemoteSession).Client {
+		t.Fatal("expected the sessions to share one client")
+	}
+	waitConnections(t, server, 1)
+
+	//a config checking host keys differently must not reuse the connection
+	insecure := config
+	insecure.SetHostKeyPolicy(HostKeyInsecure)
+	third, err := p.Get(insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.(*RemoteSession).Client == first.(*RemoteSession).Client || p.Len() != 2 {
+		t.Fatalf("expected a connection of its own, got %d pooled connections", p.Len())
+	}
+	waitConnections(t, server, 2)
+
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConnections(t, server, 0)
+}
+
+func TestPool_Reap(t *testing.T) {
+	server, config := startTestServer(t)
+	p := NewPool(time.Millisecond)
+	defer p.Close()
+	session, err := p.Get(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConnections(t, server, 1)
+	time.Sleep(10 * time.Millisecond)
+	if p.Len() != 1 {
+		t.Fatal("expected a connection in use to be kept")
+	}
+	err = session.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConnections(t, server, 0)
+	if p.Len() != 0 {
+		t.Fatalf("expected the idle connection to be reaped, got %d", p.Len())
+	}
+}
+
+func TestNewPoolKey(t *testing.T) {
+	config := NewConfig(false, "10.0.0.1", "root", "hunter2", 22)
+	config.AddAuth(KeyFileAuth("/root/.ssh/id_rsa", "passphrase"))
+	key := newPoolKey(config)
+	for _, secret := range []string{"hunter2", "passphrase"} {
+		if strings.Contains(fmt.Sprintf("%+v", key), secret) {
+			t.Fatalf("key %+v contains the secret %q", key, secret)
+		}
+	}
+	other := NewConfig(false, "10.0.0.1", "root", "hunter2", 22)
+	other.AddAuth(KeyFileAuth("/root/.ssh/id_rsa", "passphrase"))
+	if newPoolKey(other) != key {
+		t.Fatal("equal configs must share a key")
+	}
+	other = NewConfig(false, "10.0.0.1", "root", "hunter3", 22)
+	other.AddAuth(KeyFileAuth("/root/.ssh/id_rsa", "passphrase"))
+	if newPoolKey(other) == key {
+		t.Fatal("configs with different passwords must not share a key")
+	}
+
+	jump := NewConfig(false, "10.0.0.2", "root", "jump", 22)
+	config.AddJumpHost(jump)
+	key = newPoolKey(config)
+	other = NewConfig(false, "10.0.0.1", "root", "hunter2", 22)
+	other.AddAuth(KeyFileAuth("/root/.ssh/id_rsa", "passphrase"))
+	other.AddJumpHost(NewConfig(false, "10.0.0.2", "root", "other", 22))
+	if newPoolKey(other) == key {
+		t.Fatal("configs with different jump host passwords must not share a key")
+	}
+}
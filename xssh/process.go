@@ -0,0 +1,186 @@
+package xssh
+
+import (
+	"bufio"
+	"context"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Process is a command started with Session.Start. Stdout and Stderr must be
+// read until EOF before Wait is called, otherwise output may be lost.
+type Process interface {
+	Stdout() io.Reader
+	Stderr() io.Reader
+	Wait() (*Result, error)
+	Kill() error
+}
+
+type Stream int
+
+const (
+	StreamStdout Stream = iota
+	StreamStderr
+)
+
+func (s Stream) String() string {
+	if s == StreamStderr {
+		return "stderr"
+	}
+	return "stdout"
+}
+
+type LineFunc func(stream Stream, line string)
+
+// ExecLines starts cmd and calls fn for every line written to stdout or stderr
+// as soon as it arrives. fn is never called concurrently. The output is not
+// kept in the returned result.
+func ExecLines(ctx context.Context, session Session, cmd *Cmd, fn LineFunc) (*Result, error) {
+	process, err := session.Start(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		readLines(process.Stdout(), StreamStdout, fn, lock)
+	}()
+	go func() {
+		defer wg.Done()
+		readLines(process.Stderr(), StreamStderr, fn, lock)
+	}()
+	wg.Wait()
+	return process.Wait()
+}
+
+func readLines(r io.Reader, stream Stream, fn LineFunc, lock *sync.Mutex) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			lock.Lock()
+			fn(stream, line)
+			lock.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// waitProcess copies the output of process to stdout and stderr, which may be nil, and waits for it to exit.
+func waitProcess(process Process, stdout io.Writer, stderr io.Writer) (*Result, error) {
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(stdout, process.Stdout())
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(stderr, process.Stderr())
+	}()
+	wg.Wait()
+	result, err := process.Wait()
+	result.setOutput(stdout, stderr)
+	return result, err
+}
+
+type localProcess struct {
+	cmd    *exec.Cmd
+	ctx    context.Context
+	cancel context.CancelFunc
+	exited chan struct{}
+	result *Result
+	stdout *os.File
+	stderr *os.File
+}
+
+// watch kills the process when ctx is done. Closing the pipes as well unblocks
+// readers when children of the process still hold the write ends.
+func (p *localProcess) watch() {
+	select {
+	case <-p.ctx.Done():
+		_ = p.Kill()
+		closeAll(p.stdout, p.stderr)
+	case <-p.exited:
+	}
+}
+
+func (p *localProcess) Stdout() io.Reader {
+	return p.stdout
+}
+
+func (p *localProcess) Stderr() io.Reader {
+	return p.stderr
+}
+
+func (p *localProcess) Wait() (*Result, error) {
+	err := p.result.finish(p.ctx, p.cmd.Wait())
+	close(p.exited)
+	p.cancel()
+	closeAll(p.stdout, p.stderr)
+	return p.result, err
+}
+
+func (p *localProcess) Kill() error {
+	return p.cmd.Process.Kill()
+}
+
+type remoteProcess struct {
+	session *ssh.Session
+	done    <-chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	exited  chan struct{}
+	result  *Result
+	stdout  io.Reader
+	stderr  io.Reader
+}
+
+func (p *remoteProcess) watch() {
+	select {
+	case <-p.ctx.Done():
+		_ = p.Kill()
+	case <-p.exited:
+	}
+}
+
+func (p *remoteProcess) Stdout() io.Reader {
+	return p.stdout
+}
+
+func (p *remoteProcess) Stderr() io.Reader {
+	return p.stderr
+}
+
+func (p *remoteProcess) Wait() (*Result, error) {
+	err := p.session.Wait()
+	if p.ctx.Err() == nil {
+		err = connectionLost(err, p.done)
+	}
+	err = p.result.finish(p.ctx, err)
+	close(p.exited)
+	p.cancel()
+	_ = p.session.Close()
+	return p.result, err
+}
+
+func (p *remoteProcess) Kill() error {
+	_ = p.session.Signal(ssh.SIGKILL)
+	return p.session.Close()
+}
@@ -0,0 +1,54 @@
+package xssh
+
+import (
+	"context"
+	"io/ioutil"
+	"runtime"
+	"testing"
+)
+
+func TestLocalSession_Start(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires a POSIX shell")
+	}
+	session := &LocalSession{}
+	process, err := session.Start(context.Background(), NewCmd("echo", "hello").Raw(";").Arg("echo", "oops").Raw(">&2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := ioutil.ReadAll(process.Stderr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := ioutil.ReadAll(process.Stdout())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := process.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stdout) != "hello\n" || string(stderr) != "oops\n" || !result.Success() {
+		t.Fatalf("unexpected output %q %q %+v", stdout, stderr, result)
+	}
+}
+
+func TestExecLines(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires a POSIX shell")
+	}
+	var stdout, stderr []string
+	_, err := ExecLines(context.Background(), &LocalSession{}, NewCmd("printf", `a\nb\r\nc`).Raw(";").Arg("echo", "e").Raw(">&2"), func(stream Stream, line string) {
+		if stream == StreamStderr {
+			stderr = append(stderr, line)
+		} else {
+			stdout = append(stdout, line)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stdout) != 3 || stdout[0] != "a" || stdout[1] != "b" || stdout[2] != "c" || len(stderr) != 1 || stderr[0] != "e" {
+		t.Fatalf("unexpected lines %q %q", stdout, stderr)
+	}
+}
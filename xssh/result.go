@@ -0,0 +1,123 @@
+package xssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrEmptyCommand = errors.New("command is empty")
+)
+
+type Result struct {
+	Host    string
+	Command string
+	// ExitCode is -1 when the process did not report an exit status, e.g. it was killed by a signal.
+	ExitCode   int
+	ExitSignal string
+	Stdout     []byte
+	Stderr     []byte
+	Start      time.Time
+	End        time.Time
+}
+
+func newResult(host string, cmd *Cmd) *Result {
+	return &Result{Host: host, Command: cmd.String(), Start: time.Now()}
+}
+
+func (r *Result) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+func (r *Result) Success() bool {
+	return r.ExitCode == 0 && r.ExitSignal == ""
+}
+
+// ExitError is returned when a command ran but exited with a non-zero status or a signal.
+type ExitError struct {
+	*Result
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("command %q on %s ", e.Command, e.Host)
+	if e.ExitSignal != "" {
+		msg += "killed by signal " + e.ExitSignal
+	} else {
+		msg += fmt.Sprintf("exited with status %d", e.ExitCode)
+	}
+	if stderr := strings.TrimSpace(string(e.Stderr)); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+func (r *Result) setOutput(stdout io.Writer, stderr io.Writer) {
+	if buf, ok := stdout.(*bytes.Buffer); ok {
+		r.Stdout = buf.Bytes()
+	}
+	if buf, ok := stderr.(*bytes.Buffer); ok {
+		r.Stderr = buf.Bytes()
+	}
+}
+
+// finish records the end of the command and converts err into an *ExitError when the command exited unsuccessfully.
+func (r *Result) finish(ctx context.Context, err error) error {
+	r.End = time.Now()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		r.ExitCode = -1
+		return ctx.Err()
+	}
+	var sshExitErr *ssh.ExitError
+	if errors.As(err, &sshExitErr) {
+		r.ExitCode = sshExitErr.ExitStatus()
+		r.ExitSignal = sshExitErr.Signal()
+		if r.ExitSignal != "" {
+			r.ExitCode = -1
+		}
+		return &ExitError{Result: r}
+	}
+	var execExitErr *exec.ExitError
+	if errors.As(err, &execExitErr) {
+		r.ExitCode = execExitErr.ExitCode()
+		if status, ok := execExitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			r.ExitSignal = signalName(status.Signal())
+		}
+		return &ExitError{Result: r}
+	}
+	r.ExitCode = -1
+	return err
+}
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGABRT: "ABRT",
+	syscall.SIGALRM: "ALRM",
+	syscall.SIGFPE:  "FPE",
+	syscall.SIGHUP:  "HUP",
+	syscall.SIGILL:  "ILL",
+	syscall.SIGINT:  "INT",
+	syscall.SIGKILL: "KILL",
+	syscall.SIGPIPE: "PIPE",
+	syscall.SIGQUIT: "QUIT",
+	syscall.SIGSEGV: "SEGV",
+	syscall.SIGTERM: "TERM",
+}
+
+// signalName returns the ssh (RFC 4254) name of sig so that local and remote results look alike.
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return strconv.Itoa(int(sig))
+}
@@ -0,0 +1,36 @@
+package xssh
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestLocalSession_Exec(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires a POSIX shell")
+	}
+	session := &LocalSession{}
+	result, err := session.Exec(context.Background(), NewCmd("echo", "hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Stdout) != "hello world\n" || !result.Success() || result.Host != "127.0.0.1" || result.End.Before(result.Start) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	result, err = session.Exec(context.Background(), NewCmd("echo", "out").Raw(";").Arg("echo", "err").Raw(">&2", ";", "exit", "3"))
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("want *ExitError, got %v", err)
+	}
+	if exitErr.ExitCode != 3 || string(exitErr.Stdout) != "out\n" || string(exitErr.Stderr) != "err\n" || result != exitErr.Result {
+		t.Fatalf("unexpected result %+v", exitErr.Result)
+	}
+
+	_, err = session.Exec(context.Background(), NewCmd("kill", "-9").Raw("$$"))
+	if !errors.As(err, &exitErr) || exitErr.ExitSignal != "KILL" || exitErr.ExitCode != -1 {
+		t.Fatalf("want KILL signal, got %v", err)
+	}
+}
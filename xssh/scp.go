@@ -0,0 +1,595 @@
+package xssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrScpProtocol = errors.New("scp protocol error")
+	ErrScpResume   = errors.New("resumable transfers are not supported over scp")
+)
+
+// scpConn is a remote scp command speaking the scp protocol on its stdin and
+// stdout, either as the sink of an upload (scp -t) or as the source of a
+// download (scp -f). Every message is answered with a status byte: 0 for
+// success, 1 for a warning and 2 for a fatal error, the latter two followed
+// by a message line.
+type scpConn struct {
+	session    *ssh.Session
+	stdin      io.WriteCloser
+	stdout     *bufio.Reader
+	stderr     *bytes.Buffer
+	stderrDone chan struct{}
+	exited     chan struct{}
+}
+
+// startScp runs scp with arg. The session is closed when ctx is done.
+func (s *RemoteSession) startScp(ctx context.Context, arg ...string) (*scpConn, error) {
+	session, _, err := s.newSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	err = session.Start(NewCmd("scp", arg...).String())
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	c := &scpConn{
+		session:    session,
+		stdin:      stdin,
+		stdout:     bufio.NewReader(stdout),
+		stderr:     &bytes.Buffer{},
+		stderrDone: make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+	go func() {
+		_, _ = io.Copy(c.stderr, stderr)
+		close(c.stderrDone)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = session.Close()
+		case <-c.exited:
+		}
+	}()
+	return c, nil
+}
+
+// scpArgs returns the arguments of the remote scp in mode -t or -f.
+func scpArgs(mode string, recursive bool, opts TransferOptions, target string) []string {
+	arg := []string{mode}
+	if recursive {
+		arg = append(arg, "-r")
+	}
+	if opts.Preserve {
+		arg = append(arg, "-p")
+	}
+	if mode == "-t" {
+		//target is the directory receiving the entry
+		arg = append(arg, "-d")
+	}
+	return append(arg, "--", target)
+}
+
+// close ends the transfer, which failed with err when it is not nil, and
+// waits for scp to exit.
+func (c *scpConn) close(ctx context.Context, err error) error {
+	_ = c.stdin.Close()
+	if err != nil {
+		_ = c.session.Close()
+	}
+	waitErr := c.session.Wait()
+	close(c.exited)
+	<-c.stderrDone
+	_ = c.session.Close()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil {
+		err = waitErr
+	}
+	if err == nil {
+		return nil
+	}
+	if stderr := strings.TrimSpace(c.stderr.String()); stderr != "" && !strings.Contains(err.Error(), stderr) {
+		return fmt.Errorf("%w: %s", err, stderr)
+	}
+	return err
+}
+
+// ack reads the status byte answering the last message.
+func (c *scpConn) ack() error {
+	b, err := c.stdout.ReadByte()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		line, _ := c.stdout.ReadString('\n')
+		return errors.New(strings.TrimSpace(line))
+	default:
+		return fmt.Errorf("%w: unexpected status %q", ErrScpProtocol, b)
+	}
+}
+
+func (c *scpConn) reply() error {
+	_, err := c.stdin.Write([]byte{0})
+	return err
+}
+
+func (c *scpConn) send(format string, a ...interface{}) error {
+	_, err := fmt.Fprintf(c.stdin, format, a...)
+	if err != nil {
+		return err
+	}
+	return c.ack()
+}
+
+// scpSource sends local files to a remote scp -t.
+type scpSource struct {
+	*transferrer
+	conn  *scpConn
+	sumOf sumFunc
+}
+
+func (t *scpSource) dir(srcPath string, dstPath string, info os.FileInfo) error {
+	err := t.times(info)
+	if err != nil {
+		return err
+	}
+	err = t.conn.send("D%04o 0 %s\n", info.Mode().Perm()|0700, path.Base(dstPath))
+	if err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(srcPath)
+	if err != nil {
+		return err
+	}
+	for _, child := range infos {
+		childSrc := filepath.Join(srcPath, child.Name())
+		childDst := path.Join(dstPath, child.Name())
+		switch {
+		case child.IsDir():
+			err = t.dir(childSrc, childDst, child)
+		case child.Mode().IsRegular():
+			err = t.file(childSrc, childDst, child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return t.conn.send("E\n")
+}
+
+func (t *scpSource) file(srcPath string, dstPath string, info os.FileInfo) error {
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = t.times(info)
+	if err != nil {
+		return err
+	}
+	return t.send(file, srcPath, dstPath, info.Size(), info.Mode())
+}
+
+// times announces the times of the next entry when they are preserved.
+func (t *scpSource) times(info os.FileInfo) error {
+	if !t.opts.Preserve {
+		return nil
+	}
+	mtime := info.ModTime().Unix()
+	return t.conn.send("T%d 0 %d 0\n", mtime, mtime)
+}
+
+// send sends size bytes of r as the file dstPath, name is the path reported as progress.
+func (t *scpSource) send(r io.Reader, name string, dstPath string, size int64, mode os.FileMode) error {
+	err := t.conn.send("C%04o %d %s\n", mode.Perm(), size, path.Base(dstPath))
+	if err != nil {
+		return err
+	}
+	sum := sha256.New()
+	before := t.totalBytes
+	err = t.copy(t.conn.stdin, io.LimitReader(r, size), name, size, 0, sum)
+	if err != nil {
+		return err
+	}
+	if t.totalBytes-before != size {
+		//the remote scp would wait for the missing bytes
+		return fmt.Errorf("%s changed during the transfer", name)
+	}
+	err = t.conn.reply()
+	if err != nil {
+		return err
+	}
+	err = t.conn.ack()
+	if err != nil {
+		return err
+	}
+	return t.verify(t.sumOf, dstPath, sum)
+}
+
+// scpSink receives the files of a remote scp -f. When w is set, the source
+// must be a single file which is written to w.
+type scpSink struct {
+	*transferrer
+	conn  *scpConn
+	sumOf sumFunc
+	w     io.Writer
+}
+
+type scpDir struct {
+	remote string
+	local  string
+	mode   os.FileMode
+	mtime  time.Time
+}
+
+// receive writes the entry sent by the source to localPath and its children
+// below it. remotePath is the path the source was started with.
+func (t *scpSink) receive(remotePath string, localPath string) error {
+	err := t.conn.reply()
+	if err != nil {
+		return err
+	}
+	var mtime time.Time
+	var mode os.FileMode
+	var size int64
+	var name string
+	dirs := make([]scpDir, 0)
+	received := false
+	for {
+		line, err := t.conn.stdout.ReadString('\n')
+		if err == io.EOF && line == "" && received && len(dirs) == 0 {
+			return nil
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return fmt.Errorf("%w: empty message", ErrScpProtocol)
+		}
+		if line[0] == 1 || line[0] == 2 {
+			return errors.New(strings.TrimSpace(line[1:]))
+		}
+		if received && len(dirs) == 0 {
+			return fmt.Errorf("%w: more than one entry for %s", ErrScpProtocol, remotePath)
+		}
+		remote, local := remotePath, localPath
+		switch line[0] {
+		case 'T':
+			mtime, err = parseScpTimes(line)
+			if err != nil {
+				return err
+			}
+			err = t.conn.reply()
+		case 'C':
+			mode, size, name, err = parseScpEntry(line)
+			if err != nil {
+				return err
+			}
+			if len(dirs) != 0 {
+				parent := dirs[len(dirs)-1]
+				remote, local = path.Join(parent.remote, name), filepath.Join(parent.local, name)
+			} else {
+				t.totalSize = size
+			}
+			if len(dirs) == 0 && t.w == nil {
+				err = t.dst.MkdirAll(filepath.Dir(localPath), 0755)
+				if err != nil {
+					return err
+				}
+			}
+			err = t.file(remote, local, mode, size, mtime)
+			received = len(dirs) == 0 || received
+			mtime = time.Time{}
+		case 'D':
+			mode, _, name, err = parseScpEntry(line)
+			if err != nil {
+				return err
+			}
+			if t.w != nil {
+				return fmt.Errorf("%s is not a regular file", remotePath)
+			}
+			if len(dirs) != 0 {
+				parent := dirs[len(dirs)-1]
+				remote, local = path.Join(parent.remote, name), filepath.Join(parent.local, name)
+			}
+			err = t.dst.MkdirAll(local, mode|0700)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, scpDir{remote: remote, local: local, mode: mode, mtime: mtime})
+			mtime = time.Time{}
+			err = t.conn.reply()
+		case 'E':
+			if len(dirs) == 0 {
+				return fmt.Errorf("%w: unexpected end of directory", ErrScpProtocol)
+			}
+			dir := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			err = t.preserve(dir.local, dir.mode, dir.mtime)
+			if err != nil {
+				return err
+			}
+			received = len(dirs) == 0
+			err = t.conn.reply()
+		default:
+			return fmt.Errorf("%w: unexpected message %q", ErrScpProtocol, line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *scpSink) file(remote string, local string, mode os.FileMode, size int64, mtime time.Time) error {
+	w := t.w
+	if w == nil {
+		file, err := t.dst.Create(local, mode)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	err := t.conn.reply()
+	if err != nil {
+		return err
+	}
+	sum := sha256.New()
+	before := t.totalBytes
+	err = t.copy(w, io.LimitReader(t.conn.stdout, size), remote, size, 0, sum)
+	if err != nil {
+		return err
+	}
+	if t.totalBytes-before != size {
+		return io.ErrUnexpectedEOF
+	}
+	err = t.conn.ack()
+	if err != nil {
+		return err
+	}
+	if t.w == nil {
+		if closer, ok := w.(io.Closer); ok {
+			err = closer.Close()
+			if err != nil {
+				return err
+			}
+		}
+		err = t.preserve(local, mode, mtime)
+		if err != nil {
+			return err
+		}
+	}
+	err = t.verify(t.sumOf, remote, sum)
+	if err != nil {
+		return err
+	}
+	return t.conn.reply()
+}
+
+func (t *scpSink) preserve(local string, mode os.FileMode, mtime time.Time) error {
+	if !t.opts.Preserve {
+		return nil
+	}
+	err := t.dst.Chmod(local, mode)
+	if err != nil {
+		return err
+	}
+	if mtime.IsZero() {
+		return nil
+	}
+	return t.dst.Chtimes(local, mtime, mtime)
+}
+
+// parseScpTimes parses "T<mtime> <usec> <atime> <usec>" and returns the modification time.
+func parseScpTimes(line string) (time.Time, error) {
+	fields := strings.Fields(line[1:])
+	if len(fields) != 4 {
+		return time.Time{}, fmt.Errorf("%w: malformed times %q", ErrScpProtocol, line)
+	}
+	sec, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: malformed times %q", ErrScpProtocol, line)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// parseScpEntry parses "C<mode> <size> <name>" and "D<mode> 0 <name>".
+func parseScpEntry(line string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(line[1:], " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("%w: malformed entry %q", ErrScpProtocol, line)
+	}
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("%w: malformed entry %q", ErrScpProtocol, line)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("%w: malformed entry %q", ErrScpProtocol, line)
+	}
+	name := parts[2]
+	//a hostile source must not write outside of the destination
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return 0, 0, "", fmt.Errorf("%w: invalid file name %q", ErrScpProtocol, name)
+	}
+	return os.FileMode(mode).Perm(), size, name, nil
+}
+
+// scpSum computes the checksum with sha256sum, or by reading the file back
+// when it is not available.
+func (s *RemoteSession) scpSum(name string, n int64) (string, error) {
+	sum, ok := remoteSum(s.exec, name, n)
+	if ok {
+		return sum, nil
+	}
+	if n >= 0 {
+		return "", fmt.Errorf("partial checksum of %s needs sha256sum", name)
+	}
+	hash := sha256.New()
+	err := s.scpDownloadTo(context.Background(), name, hash, TransferOptions{})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// scpMakeDir creates the directory receiving an upload.
+func (s *RemoteSession) scpMakeDir(dir string) error {
+	posix, err := s.posixShell()
+	if err != nil {
+		return err
+	}
+	if !posix {
+		return ErrUnsupportedPlatform
+	}
+	return s.shell().MakeDirAll(dir, 0755)
+}
+
+func (s *RemoteSession) scpUpload(ctx context.Context, localPath string, remotePath string, opts TransferOptions) error {
+	if opts.Resume {
+		return ErrScpResume
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	t := &transferrer{ctx: ctx, src: localFS{}, opts: opts}
+	if info.IsDir() {
+		t.totalSize, err = t.size(localPath)
+		if err != nil {
+			return err
+		}
+	} else if info.Mode().IsRegular() {
+		t.totalSize = info.Size()
+	} else {
+		return fmt.Errorf("%s is not a regular file", localPath)
+	}
+	remotePath = path.Clean(remotePath)
+	dir := path.Dir(remotePath)
+	err = s.scpMakeDir(dir)
+	if err != nil {
+		return err
+	}
+	conn, err := s.startScp(ctx, scpArgs("-t", info.IsDir(), opts, dir)...)
+	if err != nil {
+		return err
+	}
+	source := &scpSource{transferrer: t, conn: conn, sumOf: s.scpSum}
+	err = conn.ack()
+	if err == nil {
+		if info.IsDir() {
+			err = source.dir(localPath, remotePath, info)
+		} else {
+			err = source.file(localPath, remotePath, info)
+		}
+	}
+	return conn.close(ctx, err)
+}
+
+func (s *RemoteSession) scpUploadFrom(ctx context.Context, r io.Reader, remotePath string, opts TransferOptions) error {
+	size, ok := readerSize(r, opts)
+	if !ok {
+		//scp announces the size of a file before its content
+		tmp, err := ioutil.TempFile("", "xssh-scp-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		size, err = io.Copy(tmp, r)
+		if err != nil {
+			return err
+		}
+		_, err = tmp.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		r = tmp
+	}
+	remotePath = path.Clean(remotePath)
+	dir := path.Dir(remotePath)
+	err := s.scpMakeDir(dir)
+	if err != nil {
+		return err
+	}
+	opts.Preserve = false
+	conn, err := s.startScp(ctx, scpArgs("-t", false, opts, dir)...)
+	if err != nil {
+		return err
+	}
+	t := &transferrer{ctx: ctx, opts: opts, totalSize: size}
+	source := &scpSource{transferrer: t, conn: conn, sumOf: s.scpSum}
+	err = conn.ack()
+	if err == nil {
+		err = source.send(r, "", remotePath, size, opts.mode())
+	}
+	return conn.close(ctx, err)
+}
+
+func (s *RemoteSession) scpDownload(ctx context.Context, remotePath string, localPath string, opts TransferOptions) error {
+	if opts.Resume {
+		return ErrScpResume
+	}
+	conn, err := s.startScp(ctx, scpArgs("-f", true, opts, remotePath)...)
+	if err != nil {
+		return err
+	}
+	t := &transferrer{ctx: ctx, dst: localFS{}, opts: opts, totalSize: -1}
+	sink := &scpSink{transferrer: t, conn: conn, sumOf: s.scpSum}
+	return conn.close(ctx, sink.receive(remotePath, localPath))
+}
+
+func (s *RemoteSession) scpDownloadTo(ctx context.Context, remotePath string, w io.Writer, opts TransferOptions) error {
+	opts.Preserve = false
+	conn, err := s.startScp(ctx, scpArgs("-f", false, opts, remotePath)...)
+	if err != nil {
+		return err
+	}
+	t := &transferrer{ctx: ctx, opts: opts, totalSize: -1}
+	sink := &scpSink{transferrer: t, conn: conn, sumOf: s.scpSum, w: w}
+	return conn.close(ctx, sink.receive(remotePath, ""))
+}
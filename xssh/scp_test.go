@@ -0,0 +1,155 @@
+package xssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startExecServer starts an ssh server which runs commands with sh and has no
+// sftp subsystem, and returns the config to connect to it.
+func startExecServer(t *testing.T) Config {
+	server, config := startTestServer(t)
+	server.SetSftp(false)
+	return config
+}
+
+func TestRemoteSession_UploadScp(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp is not installed")
+	}
+	session := &RemoteSession{Config: startExecServer(t)}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if session.Sftp != nil {
+		t.Fatal("expected no sftp client")
+	}
+	src := filepath.Join(t.TempDir(), "src")
+	mtime := writeTestTree(t, src)
+	remote := filepath.Join(t.TempDir(), "remote", "copy")
+	var last Progress
+	err = session.Upload(context.Background(), src, remote, TransferOptions{
+		Preserve: true,
+		Verify:   true,
+		Progress: func(progress Progress) {
+			last = progress
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.TotalSize != int64(3*transferBufferSize+7+5+10) || last.TotalBytes != last.TotalSize {
+		t.Fatalf("unexpected progress %+v", last)
+	}
+	local := filepath.Join(t.TempDir(), "local")
+	err = session.Download(context.Background(), remote, local, TransferOptions{Preserve: true, Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.bin", "sub/deep/c.sh"} {
+		want, _ := ioutil.ReadFile(filepath.Join(src, name))
+		got, err := ioutil.ReadFile(filepath.Join(local, name))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("unexpected copy of %s: %v", name, err)
+		}
+	}
+	info, err := os.Stat(filepath.Join(local, "sub/deep/c.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("mode and mtime not preserved: %v %v", info.Mode(), info.ModTime())
+	}
+}
+
+func TestRemoteSession_UploadFromScp(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp is not installed")
+	}
+	session := &RemoteSession{Config: startExecServer(t)}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "sub", "file")
+	err = session.UploadFrom(context.Background(), strings.NewReader("streamed"), name, TransferOptions{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	err = session.DownloadTo(context.Background(), name, buf, TransferOptions{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "streamed" {
+		t.Fatalf("unexpected content %q", buf.String())
+	}
+	//without io.Seeker, the reader is spooled unless its size is given
+	for _, opts := range []TransferOptions{{}, {Size: 7}} {
+		err = session.UploadFrom(context.Background(), struct{ io.Reader }{strings.NewReader("spooled")}, name, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		err = session.DownloadTo(context.Background(), name, buf, TransferOptions{})
+		if err != nil || buf.String() != "spooled" {
+			t.Fatalf("unexpected content %q %v", buf.String(), err)
+		}
+	}
+	err = session.DownloadTo(context.Background(), dir, buf, TransferOptions{})
+	if err == nil {
+		t.Fatal("expected an error downloading a directory to a writer")
+	}
+	err = session.DownloadTo(context.Background(), filepath.Join(dir, "missing"), buf, TransferOptions{})
+	if err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Fatalf("expected the error of scp, got %v", err)
+	}
+}
+
+func TestRemoteSession_ResumeScp(t *testing.T) {
+	session := &RemoteSession{Config: startExecServer(t)}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	local := filepath.Join(t.TempDir(), "file")
+	err = ioutil.WriteFile(local, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.Join(t.TempDir(), "file")
+	err = session.Upload(context.Background(), local, remote, TransferOptions{Resume: true})
+	if !errors.Is(err, ErrScpResume) {
+		t.Fatalf("expected ErrScpResume, got %v", err)
+	}
+	err = session.Download(context.Background(), remote, local, TransferOptions{Resume: true})
+	if !errors.Is(err, ErrScpResume) {
+		t.Fatalf("expected ErrScpResume, got %v", err)
+	}
+}
+
+func TestParseScpEntry(t *testing.T) {
+	mode, size, name, err := parseScpEntry("C0640 12 a file")
+	if err != nil || mode != 0640 || size != 12 || name != "a file" {
+		t.Fatalf("unexpected entry %v %d %q %v", mode, size, name, err)
+	}
+	for _, line := range []string{"C0644 1 ../x", "C0644 1 a/b", "D0755 0 ..", "C0644 -1 x", "C0644 1", "Cxyz 1 x"} {
+		_, _, _, err = parseScpEntry(line)
+		if !errors.Is(err, ErrScpProtocol) {
+			t.Fatalf("expected ErrScpProtocol for %q, got %v", line, err)
+		}
+	}
+}
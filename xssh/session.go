@@ -2,20 +2,22 @@ package xssh
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"fmt"
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"runtime"
-	"strconv"
 	"strings"
-	"time"
+	"sync"
 )
 
 var (
-	ErrNilSshClient = errors.New("ssh client is nil")
+	ErrNilSshClient        = errors.New("ssh client is nil")
+	ErrUnsupportedPlatform = errors.New("operation is not supported on this platform")
 )
 
 type Session interface {
@@ -25,26 +27,36 @@ type Session interface {
 	Run(name string, arg ...string) error
 	Output(name string, arg ...string) ([]byte, error)
 	CombinedOutput(name string, arg ...string) ([]byte, error)
-	OutputGrep(cmdList []struct {
-		name string
-		arg  []string
-	}) ([]byte, error)
+	RunContext(ctx context.Context, name string, arg ...string) error
+	OutputContext(ctx context.Context, name string, arg ...string) ([]byte, error)
+	CombinedOutputContext(ctx context.Context, name string, arg ...string) ([]byte, error)
+	Exec(ctx context.Context, cmd *Cmd) (*Result, error)
+	Start(ctx context.Context, cmd *Cmd) (Process, error)
+	Shell(ctx context.Context, opts TerminalOptions) (Terminal, error)
 	Exists(path string) (bool, error)
 	ReadFile(fileName string) ([]byte, error)
 	ReadDir(dir string) ([]FileInfo, error)
+	Stat(name string) (FileInfo, error)
+	Lstat(name string) (FileInfo, error)
 	MakeDirAll(path string, perm os.FileMode) error
 	Remove(name string) error
 	RemoveAll(path string) error
 	Create(name string) error
 	WriteString(name string, data string, mode ...string) error
+	Upload(ctx context.Context, localPath string, remotePath string, opts TransferOptions) error
+	Download(ctx context.Context, remotePath string, localPath string, opts TransferOptions) error
+	UploadFrom(ctx context.Context, r io.Reader, remotePath string, opts TransferOptions) error
+	DownloadTo(ctx context.Context, remotePath string, w io.Writer, opts TransferOptions) error
+	Platform() (Platform, error)
+	Sudo() Session
 }
 
 func NewSession(config Config) (Session, error) {
 	var session Session
 	if config.IsLocal() {
-		session = &LocalSession{}
+		session = &LocalSession{Config: config}
 	} else {
-		session = &RemoteSession{}
+		session = &RemoteSession{Config: config}
 	}
 	err := session.Connect()
 	if err != nil {
@@ -54,6 +66,8 @@ func NewSession(config Config) (Session, error) {
 }
 
 type LocalSession struct {
+	Config
+	sudo bool
 }
 
 func (s *LocalSession) IsLinux() bool {
@@ -64,6 +78,20 @@ func (s *LocalSession) IsLocal() bool {
 	return true
 }
 
+// windows reports whether commands run with cmd.exe rather than sh.
+func (s *LocalSession) windows() bool {
+	return runtime.GOOS == "windows"
+}
+
+// Sudo returns a view of the session that runs every command and file operation with sudo.
+func (s *LocalSession) Sudo() Session {
+	return &LocalSession{Config: s.Config, sudo: true}
+}
+
+func (s *LocalSession) shell() shellFS {
+	return shellFS{exec: s.exec, bsd: Platform{Family: OSFamily(runtime.GOOS)}.bsd()}
+}
+
 func (s *LocalSession) Connect() error {
 	return nil
 }
@@ -73,100 +101,203 @@ func (s *LocalSession) Close() error {
 }
 
 func (s *LocalSession) Run(name string, arg ...string) error {
-	if s.IsLinux() {
-		return exec.Command(name, arg...).Run()
-	} else {
-		args := make([]string, len(arg)+5)
-		args[0] = "/c"
-		args[1] = name
-		copy(args[2:], arg)
-		return exec.Command("cmd", args...).Run()
-	}
+	return s.RunContext(context.Background(), name, arg...)
 }
 
 func (s *LocalSession) Output(name string, arg ...string) ([]byte, error) {
-	if s.IsLinux() {
-		return exec.Command(name, arg...).Output()
-	} else {
-		args := make([]string, len(arg)+5)
-		args[0] = "/c"
-		args[1] = name
-		copy(args[2:], arg)
-		return exec.Command("cmd", args...).Output()
-	}
+	return s.OutputContext(context.Background(), name, arg...)
 }
 
 func (s *LocalSession) CombinedOutput(name string, arg ...string) ([]byte, error) {
-	if s.IsLinux() {
-		return exec.Command(name, arg...).CombinedOutput()
-	} else {
-		args := make([]string, len(arg)+5)
-		args[0] = "/c"
-		args[1] = name
-		copy(args[2:], arg)
-		return exec.Command("cmd", args...).CombinedOutput()
+	return s.CombinedOutputContext(context.Background(), name, arg...)
+}
+
+func (s *LocalSession) RunContext(ctx context.Context, name string, arg ...string) error {
+	_, err := s.exec(ctx, NewCmd(name, arg...), nil, &bytes.Buffer{})
+	return err
+}
+
+func (s *LocalSession) OutputContext(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	result, err := s.exec(ctx, NewCmd(name, arg...), &bytes.Buffer{}, &bytes.Buffer{})
+	if err != nil {
+		return nil, err
 	}
+	return result.Stdout, nil
 }
 
-func (s *LocalSession) OutputGrep(cmdList []struct {
-	name string
-	arg  []string
-}) ([]byte, error) {
-	if cmdList == nil {
-		return nil, errors.New("execute cmd grep failed, cmd list is nil")
+func (s *LocalSession) CombinedOutputContext(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	output := &syncBuffer{}
+	_, err := s.exec(ctx, NewCmd(name, arg...), output, output)
+	if err != nil {
+		return nil, err
 	}
-	cmdStrList := make([]string, len(cmdList))
-	for i, cmd := range cmdList {
-		cmdStrList[i] = cmd.name
-		for _, arg := range cmd.arg {
-			cmdStrList[i] += " " + arg
-		}
+	return output.Bytes(), nil
+}
+
+func (s *LocalSession) Exec(ctx context.Context, cmd *Cmd) (*Result, error) {
+	return s.exec(ctx, cmd, &bytes.Buffer{}, &bytes.Buffer{})
+}
+
+func (s *LocalSession) Start(ctx context.Context, cmd *Cmd) (Process, error) {
+	return s.start(ctx, cmd)
+}
+
+// exec runs cmd and fills the result with the captured stdout and stderr when they are *bytes.Buffer.
+func (s *LocalSession) exec(ctx context.Context, cmd *Cmd, stdout io.Writer, stderr io.Writer) (*Result, error) {
+	process, err := s.start(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return waitProcess(process, stdout, stderr)
+}
+
+func (s *LocalSession) start(ctx context.Context, cmd *Cmd) (Process, error) {
+	if cmd.sudo || s.sudo {
+		return startSudo(ctx, cmd, s.Config.SudoPassword(), s.startProcess)
+	}
+	return s.startProcess(ctx, cmd)
+}
+
+func (s *LocalSession) startProcess(ctx context.Context, cmd *Cmd) (Process, error) {
+	err := cmd.validateLocal(s.windows())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.Config.withTimeout(ctx)
+	c := s.commandOf(ctx, cmd)
+	c.Stdin = cmd.stdin
+	c.Dir = cmd.dir
+	if len(cmd.env) != 0 {
+		c.Env = append(os.Environ(), cmd.env...)
+	}
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		cancel()
+		return nil, err
 	}
-	if s.IsLinux() {
-		return exec.Command("cmd", "/c", strings.Join(cmdStrList, " | ")).Output()
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		cancel()
+		closeAll(stdout, stdoutWriter)
+		return nil, err
+	}
+	c.Stdout = stdoutWriter
+	c.Stderr = stderrWriter
+	result := newResult(s.Config.Host(), cmd)
+	err = c.Start()
+	closeAll(stdoutWriter, stderrWriter)
+	if err != nil {
+		cancel()
+		closeAll(stdout, stderr)
+		return nil, err
+	}
+	process := &localProcess{
+		cmd:    c,
+		ctx:    ctx,
+		cancel: cancel,
+		exited: make(chan struct{}),
+		result: result,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	go process.watch()
+	return process, nil
+}
+
+func (s *LocalSession) commandOf(ctx context.Context, cmd *Cmd) *exec.Cmd {
+	if !cmd.isRaw() {
+		argv := cmd.argv()
+		return s.command(ctx, argv[0], argv[1:]...)
+	}
+	if !s.windows() {
+		return exec.CommandContext(ctx, "sh", "-c", cmd.String())
 	} else {
-		return exec.Command("bash", "-c", strings.Join(cmdStrList, " | ")).Output()
+		return exec.CommandContext(ctx, "cmd", "/c", cmd.String())
+	}
+}
+
+func (s *LocalSession) command(ctx context.Context, name string, arg ...string) *exec.Cmd {
+	if !s.windows() {
+		return exec.CommandContext(ctx, name, arg...)
+	} else {
+		args := make([]string, len(arg)+2)
+		args[0] = "/c"
+		args[1] = name
+		copy(args[2:], arg)
+		return exec.CommandContext(ctx, "cmd", args...)
 	}
 }
 
 func (s *LocalSession) Exists(path string) (bool, error) {
+	if s.sudo {
+		return s.shell().Exists(path)
+	}
 	return Exists(path), nil
 }
 
 func (s *LocalSession) ReadFile(fileName string) ([]byte, error) {
+	if s.sudo {
+		return s.shell().ReadFile(fileName)
+	}
 	return ioutil.ReadFile(fileName)
 }
 
 func (s *LocalSession) ReadDir(dir string) ([]FileInfo, error) {
-	dirs, err := ioutil.ReadDir(dir)
-	if err != nil {
-		return nil, err
+	if s.sudo {
+		return s.shell().ReadDir(dir)
 	}
-	files := make([]FileInfo, len(dirs))
-	for i, fileInfo := range dirs {
-		files[i] = FileInfo{Name: fileInfo.Name(), Path: fileInfo.Name()}
+	return localReadDir(dir)
+}
+
+// Stat returns the description of name, following symbolic links.
+func (s *LocalSession) Stat(name string) (FileInfo, error) {
+	if s.sudo {
+		return s.shell().Stat(name)
 	}
-	return files, nil
+	return localStat(name, os.Stat, localOwners())
+}
+
+// Lstat returns the description of name without following symbolic links.
+func (s *LocalSession) Lstat(name string) (FileInfo, error) {
+	if s.sudo {
+		return s.shell().Lstat(name)
+	}
+	return localStat(name, os.Lstat, localOwners())
 }
 
 func (s *LocalSession) MakeDirAll(path string, perm os.FileMode) error {
+	if s.sudo {
+		return s.shell().MakeDirAll(path, perm)
+	}
 	return os.MkdirAll(path, perm)
 }
 
 func (s *LocalSession) Remove(name string) error {
+	if s.sudo {
+		return s.shell().Remove(name)
+	}
 	return os.Remove(name)
 }
 
 func (s *LocalSession) RemoveAll(path string) error {
+	if s.sudo {
+		return s.shell().RemoveAll(path)
+	}
 	return os.RemoveAll(path)
 }
 
 func (s *LocalSession) Create(name string) error {
+	if s.sudo {
+		return s.shell().Create(name)
+	}
 	_, err := os.Create(name)
 	return err
 }
 
 func (s *LocalSession) WriteString(name string, data string, mode ...string) error {
+	if s.sudo {
+		return s.shell().WriteString(name, data, mode...)
+	}
 	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
 	if len(mode) == 1 && mode[0] == ">>" {
 		flag = os.O_RDWR | os.O_CREATE | os.O_APPEND
@@ -184,169 +315,306 @@ func (s *LocalSession) WriteString(name string, data string, mode ...string) err
 
 type RemoteSession struct {
 	Config
-	Client *ssh.Client
+	Client   *ssh.Client
+	Sftp     *sftp.Client
+	jumps    []*ssh.Client
+	pool     *Pool
+	pooled   *pooledConn
+	sudo     bool
+	origin   *RemoteSession
+	lock     sync.Mutex
+	done     chan struct{}
+	stop     chan struct{}
+	lost     error
+	platform *Platform
+	//reconnecting is closed when the reconnect in progress, if any, is over
+	reconnecting chan struct{}
 }
 
 func (s *RemoteSession) IsLinux() bool {
-	err := s.Run("cat", "/etc/os-release")
-	if err != nil {
-		return false
-	}
-	return true
+	platform, err := s.Platform()
+	return err == nil && platform.IsLinux()
 }
 
 func (s *RemoteSession) IsLocal() bool {
 	return false
 }
 
+// posixShell reports whether the file methods can fall back to POSIX shell
+// commands, i.e. the host is not Windows. It fails when the platform is unknown.
+func (s *RemoteSession) posixShell() (bool, error) {
+	platform, err := s.Platform()
+	if err != nil {
+		return false, err
+	}
+	return !platform.IsWindows(), nil
+}
+
+// Sudo returns a view of the session that runs every command and file
+// operation with sudo. It shares the connection, closing it is a no-op.
+func (s *RemoteSession) Sudo() Session {
+	client, sftpClient, done := s.current()
+	return &RemoteSession{Config: s.Config, Client: client, Sftp: sftpClient, done: done, sudo: true, origin: s}
+}
+
+// current returns the connection in use with its sftp client and the channel
+// closed when it is lost. A reconnect replaces them, they are read together
+// under the lock.
+func (s *RemoteSession) current() (*ssh.Client, *sftp.Client, chan struct{}) {
+	if s.origin != nil {
+		return s.origin.current()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.Client, s.Sftp, s.done
+}
+
+// newSession opens a channel on the connection in use and returns it with the
+// channel closed when that connection is lost.
+func (s *RemoteSession) newSession() (*ssh.Session, chan struct{}, error) {
+	client, _, done := s.current()
+	if client == nil {
+		return nil, nil, ErrNilSshClient
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, nil, connectionLost(err, done)
+	}
+	return session, done, nil
+}
+
+// sftpClient returns the sftp client of the connection in use, nil when file
+// operations go through shell commands.
+func (s *RemoteSession) sftpClient() *sftp.Client {
+	if s.sudo {
+		return nil
+	}
+	_, client, _ := s.current()
+	return client
+}
+
+// shell returns the file operations with shell commands for the platform
+// found out by posixShell.
+func (s *RemoteSession) shell() shellFS {
+	platform, _ := s.Platform()
+	return shellFS{exec: s.exec, bsd: platform.bsd()}
+}
+
 func (s *RemoteSession) Connect() error {
-	if s.Client != nil {
-		err := s.Client.Close()
+	if s.origin != nil {
+		err := s.origin.Connect()
+		s.follow()
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.connect()
+}
+
+func (s *RemoteSession) connect() error {
+	//closing a lost connection fails, which must not prevent reconnecting
+	_ = s.close()
+	if s.pool != nil {
+		err := s.pool.attach(s)
 		if err != nil {
 			return err
 		}
-	}
-	var auth []ssh.AuthMethod
-	if s.Config.Password() != "" {
-		auth = []ssh.AuthMethod{ssh.Password(s.Config.Password())}
+		s.done = s.pooled.done
 	} else {
-		sshKeyPath := "/root/.ssh/id_rsa"
-		keyAuth, err := publicKeyAuth(sshKeyPath)
+		client, jumps, err := dialChain(s.Config)
 		if err != nil {
 			return err
 		}
-		auth = []ssh.AuthMethod{keyAuth}
+		s.Client = client
+		s.jumps = jumps
+		s.Sftp = newSftpClient(client)
+		s.done = clientDone(client)
+	}
+	s.lost = nil
+	s.platform = nil
+	s.stop = make(chan struct{})
+	go s.monitor(s.Client, s.pooled, s.done, s.stop)
+	return nil
+}
+
+func (s *RemoteSession) Close() error {
+	if s.sudo {
+		return nil
 	}
-	sshCfg := &ssh.ClientConfig{
-		Timeout:         time.Second * 3,
-		User:            s.Config.User(),
-		Auth:            auth,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.close()
+}
+
+func (s *RemoteSession) close() error {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
 	}
-	addr := fmt.Sprintf("%s:%d", s.Config.Host(), s.Config.Port())
-	session, err := ssh.Dial("tcp", addr, sshCfg)
-	if err != nil {
-		return err
+	if s.pooled != nil {
+		s.pool.release(s.pooled)
+		s.pooled = nil
+		s.Client = nil
+		s.Sftp = nil
+		return nil
 	}
-	s.Client = session
-	return nil
+	err := closeClient(s.Sftp, s.Client, s.jumps)
+	s.Sftp = nil
+	s.Client = nil
+	s.jumps = nil
+	return err
 }
 
-func publicKeyAuth(kPath string) (ssh.AuthMethod, error) {
-	key, err := ioutil.ReadFile(kPath)
-	if err != nil {
-		return nil, err
-	}
-	singer, err := ssh.ParsePrivateKey(key)
+func (s *RemoteSession) Run(name string, arg ...string) error {
+	return s.RunContext(context.Background(), name, arg...)
+}
+
+func (s *RemoteSession) Output(name string, arg ...string) ([]byte, error) {
+	return s.OutputContext(context.Background(), name, arg...)
+}
+
+func (s *RemoteSession) CombinedOutput(name string, arg ...string) ([]byte, error) {
+	return s.CombinedOutputContext(context.Background(), name, arg...)
+}
+
+func (s *RemoteSession) RunContext(ctx context.Context, name string, arg ...string) error {
+	_, err := s.exec(ctx, NewCmd(name, arg...), nil, &bytes.Buffer{})
+	return err
+}
+
+func (s *RemoteSession) OutputContext(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	return s.outputContext(ctx, NewCmd(name, arg...))
+}
+
+func (s *RemoteSession) CombinedOutputContext(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	output := &syncBuffer{}
+	_, err := s.exec(ctx, NewCmd(name, arg...), output, output)
 	if err != nil {
 		return nil, err
 	}
-	return ssh.PublicKeys(singer), nil
+	return output.Bytes(), nil
 }
 
-func (s *RemoteSession) Close() error {
-	if s.Client != nil {
-		return s.Client.Close()
+func (s *RemoteSession) Exec(ctx context.Context, cmd *Cmd) (*Result, error) {
+	return s.exec(ctx, cmd, &bytes.Buffer{}, &bytes.Buffer{})
+}
+
+func (s *RemoteSession) Start(ctx context.Context, cmd *Cmd) (Process, error) {
+	return s.start(ctx, cmd)
+}
+
+func (s *RemoteSession) start(ctx context.Context, cmd *Cmd) (Process, error) {
+	if cmd.sudo || s.sudo {
+		return startSudo(ctx, cmd, s.Config.SudoPassword(), s.startProcess)
 	}
-	return nil
+	return s.startProcess(ctx, cmd)
 }
 
-func (s *RemoteSession) Run(name string, arg ...string) error {
-	if s.Client == nil {
-		return ErrNilSshClient
+func (s *RemoteSession) startProcess(ctx context.Context, cmd *Cmd) (Process, error) {
+	err := s.ensureConnected(ctx)
+	if err != nil {
+		return nil, err
 	}
-	session, err := s.Client.NewSession()
+	err = cmd.validate()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer session.Close()
-	session.Stderr = &bytes.Buffer{}
-	err = session.Run(Command(name, arg...))
+	session, done, err := s.newSession()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
-}
-
-func (s *RemoteSession) Output(name string, arg ...string) ([]byte, error) {
-	if s.Client == nil {
-		return nil, ErrNilSshClient
+	session.Stdin = cmd.stdin
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, err
 	}
-	session, err := s.Client.NewSession()
+	stderr, err := session.StderrPipe()
 	if err != nil {
+		_ = session.Close()
 		return nil, err
 	}
-	defer session.Close()
-	session.Stderr = &bytes.Buffer{}
-	output, err := session.Output(Command(name, arg...))
+	result := newResult(s.Config.Host(), cmd)
+	err = session.Start(remoteScript(session, cmd))
 	if err != nil {
+		_ = session.Close()
 		return nil, err
 	}
-	return output, nil
+	ctx, cancel := s.Config.withTimeout(ctx)
+	process := &remoteProcess{
+		session: session,
+		done:    done,
+		ctx:     ctx,
+		cancel:  cancel,
+		exited:  make(chan struct{}),
+		result:  result,
+		stdout:  stdout,
+		stderr:  stderr,
+	}
+	go process.watch()
+	return process, nil
+}
+
+// remoteScript sets the environment of cmd on the channel and falls back to
+// exporting it in the script when the server refuses (see AcceptEnv in sshd_config).
+func remoteScript(session *ssh.Session, cmd *Cmd) string {
+	for _, env := range cmd.env {
+		kv := strings.SplitN(env, "=", 2)
+		if session.Setenv(kv[0], kv[1]) != nil {
+			return cmd.script(true)
+		}
+	}
+	return cmd.script(false)
 }
 
-func (s *RemoteSession) CombinedOutput(name string, arg ...string) ([]byte, error) {
-	if s.Client == nil {
-		return nil, ErrNilSshClient
-	}
-	session, err := s.Client.NewSession()
+func (s *RemoteSession) run(cmd *Cmd) error {
+	_, err := s.exec(context.Background(), cmd, nil, &bytes.Buffer{})
+	return err
+}
+
+func (s *RemoteSession) output(cmd *Cmd) ([]byte, error) {
+	return s.outputContext(context.Background(), cmd)
+}
+
+func (s *RemoteSession) outputContext(ctx context.Context, cmd *Cmd) ([]byte, error) {
+	result, err := s.exec(ctx, cmd, &bytes.Buffer{}, &bytes.Buffer{})
 	if err != nil {
 		return nil, err
 	}
-	defer session.Close()
-	output, err := session.CombinedOutput(Command(name, arg...))
+	return result.Stdout, nil
+}
+
+// exec runs cmd in a new channel and fills the result with the captured stdout
+// and stderr when they are *bytes.Buffer. When ctx is done before the command
+// exits, the remote process is killed, the channel is closed and ctx.Err() is returned.
+func (s *RemoteSession) exec(ctx context.Context, cmd *Cmd, stdout io.Writer, stderr io.Writer) (*Result, error) {
+	process, err := s.start(ctx, cmd)
 	if err != nil {
 		return nil, err
 	}
-	return output, nil
+	return waitProcess(process, stdout, stderr)
 }
 
-func (s *RemoteSession) OutputGrep(cmdList []struct {
-	name string
-	arg  []string
-}) ([]byte, error) {
-	if cmdList == nil {
-		return nil, errors.New("execute cmd grep failed, cmd list is nil")
+func (s *RemoteSession) Exists(path string) (bool, error) {
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return false, err
 	}
-	if s.IsLinux() {
-		cmdStrList := make([]string, len(cmdList))
-		for i, cmd := range cmdList {
-			cmdStrList[i] = cmd.name
-			for _, arg := range cmd.arg {
-				cmdStrList[i] += " " + arg
-			}
-		}
-		return s.Output("bash", "-c", "'"+strings.Join(cmdStrList, " | ")+"'")
-	} else {
-		//TODO
-		return nil, nil
+	if client := s.sftpClient(); client != nil {
+		return sftpExists(client, path)
 	}
-}
-
-func (s *RemoteSession) Exists(path string) (bool, error) {
-	if s.Client == nil {
-		return false, ErrNilSshClient
-	}
-	var err error
-	var output []byte
-	if s.IsLinux() {
-		if strings.HasSuffix(path, "/") {
-			//dir
-			output, err = s.Output("find", path)
-		} else {
-			//file
-			output, err = s.Output("find", Dir(path), "-name", FileName(path))
-		}
+	posix, err := s.posixShell()
+	if err != nil {
+		return false, err
+	}
+	if posix {
+		return s.shell().Exists(path)
+	} else {
+		quoted, err := windowsQuote(path)
 		if err != nil {
 			return false, err
 		}
-		if len(output) != 0 {
-			return true, nil
-		} else {
-			return false, nil
-		}
-	} else {
-		output, err = s.Output("dir", "/b", path)
+		output, err := s.output(NewCmd("dir", "/b").Raw(quoted))
 		if err != nil {
 			return false, err
 		}
@@ -359,111 +627,163 @@ func (s *RemoteSession) Exists(path string) (bool, error) {
 }
 
 func (s *RemoteSession) ReadFile(fileName string) ([]byte, error) {
-	if s.Client == nil {
-		return nil, ErrNilSshClient
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return nil, err
 	}
-	if s.IsLinux() {
-		return s.Output("cat", fileName)
-	} else {
-		//TODO
-		return nil, nil
+	if client := s.sftpClient(); client != nil {
+		return sftpReadFile(client, fileName)
+	}
+	posix, err := s.posixShell()
+	if err != nil {
+		return nil, err
 	}
+	if !posix {
+		return nil, ErrUnsupportedPlatform
+	}
+	return s.shell().ReadFile(fileName)
 }
 
 func (s *RemoteSession) ReadDir(dir string) ([]FileInfo, error) {
-	if s.Client == nil {
-		return nil, ErrNilSshClient
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return nil, err
 	}
-	if s.IsLinux() {
-		output, err := s.Output("ls", "-AF", dir)
-		if err != nil {
-			return nil, err
-		}
-		files := make([]FileInfo, 0)
-		for _, file := range strings.Split(string(output), "\n") {
-			if strings.HasSuffix(file, "/") {
-				files = append(files, FileInfo{Name: file, Path: dir + file})
-			} else {
-				files = append(files, FileInfo{Name: file, Path: dir + "/" + file})
-			}
-		}
-		return files, nil
-	} else {
-		//TODO
-		return nil, nil
+	if client := s.sftpClient(); client != nil {
+		return sftpReadDir(client, dir)
+	}
+	posix, err := s.posixShell()
+	if err != nil {
+		return nil, err
+	}
+	if !posix {
+		return nil, ErrUnsupportedPlatform
 	}
+	return s.shell().ReadDir(dir)
+}
+
+func (s *RemoteSession) Stat(name string) (FileInfo, error) {
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if client := s.sftpClient(); client != nil {
+		return sftpStat(client, name, client.Stat)
+	}
+	posix, err := s.posixShell()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if !posix {
+		return FileInfo{}, ErrUnsupportedPlatform
+	}
+	return s.shell().Stat(name)
+}
+
+func (s *RemoteSession) Lstat(name string) (FileInfo, error) {
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if client := s.sftpClient(); client != nil {
+		return sftpStat(client, name, client.Lstat)
+	}
+	posix, err := s.posixShell()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if !posix {
+		return FileInfo{}, ErrUnsupportedPlatform
+	}
+	return s.shell().Lstat(name)
 }
 
 func (s *RemoteSession) MakeDirAll(path string, perm os.FileMode) error {
-	if s.Client == nil {
-		return ErrNilSshClient
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return err
 	}
-	if s.IsLinux() {
-		return s.Run("mkdir", "-p", path, "-m", strconv.FormatUint(uint64(perm), 10))
-	} else {
-		//TODO
-		return nil
+	if client := s.sftpClient(); client != nil {
+		return sftpMakeDirAll(client, path, perm)
 	}
+	posix, err := s.posixShell()
+	if err != nil {
+		return err
+	}
+	if !posix {
+		return ErrUnsupportedPlatform
+	}
+	return s.shell().MakeDirAll(path, perm)
 }
 
 func (s *RemoteSession) Remove(name string) error {
-	if s.Client == nil {
-		return ErrNilSshClient
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return err
 	}
-	if s.IsLinux() {
-		return s.Run("rm", "-f", name)
-	} else {
-		//TODO
-		return nil
+	if client := s.sftpClient(); client != nil {
+		return sftpRemove(client, name)
 	}
+	posix, err := s.posixShell()
+	if err != nil {
+		return err
+	}
+	if !posix {
+		return ErrUnsupportedPlatform
+	}
+	return s.shell().Remove(name)
 }
 
 func (s *RemoteSession) RemoveAll(path string) error {
-	if s.Client == nil {
-		return ErrNilSshClient
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return err
 	}
-	if s.IsLinux() {
-		return s.Run("rm", "-r", "-f", path)
-	} else {
-		//TODO
-		return nil
+	if client := s.sftpClient(); client != nil {
+		return sftpRemoveAll(client, path)
 	}
+	posix, err := s.posixShell()
+	if err != nil {
+		return err
+	}
+	if !posix {
+		return ErrUnsupportedPlatform
+	}
+	return s.shell().RemoveAll(path)
 }
 
 func (s *RemoteSession) Create(name string) error {
-	if s.Client == nil {
-		return ErrNilSshClient
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return err
 	}
-	if s.IsLinux() {
-		exists, err := s.Exists(Dir(name))
-		if err != nil {
-			return err
-		}
-		if !exists {
-			err = s.MakeDirAll(Dir(name), 0666)
-			if err != nil {
-				return err
-			}
-		}
-		return s.Run("touch", name)
-	} else {
-		//TODO
-		return nil
+	if client := s.sftpClient(); client != nil {
+		return sftpCreate(client, name)
+	}
+	posix, err := s.posixShell()
+	if err != nil {
+		return err
 	}
+	if !posix {
+		return ErrUnsupportedPlatform
+	}
+	return s.shell().Create(name)
 }
 
 func (s *RemoteSession) WriteString(name string, data string, mode ...string) error {
-	if s.Client == nil {
-		return ErrNilSshClient
+	err := s.ensureConnected(context.Background())
+	if err != nil {
+		return err
 	}
-	if s.IsLinux() {
-		flag := ">"
-		if len(mode) == 1 && mode[0] == ">>" {
-			flag = ">>"
-		}
-		return s.Run("echo", fmt.Sprintf(`"%s"`, data), flag)
-	} else {
-		//TODO
-		return nil
+	if client := s.sftpClient(); client != nil {
+		return sftpWriteString(client, name, data, mode...)
+	}
+	posix, err := s.posixShell()
+	if err != nil {
+		return err
+	}
+	if !posix {
+		return ErrUnsupportedPlatform
 	}
+	return s.shell().WriteString(name, data, mode...)
 }
@@ -0,0 +1,210 @@
+package xssh
+
+import (
+	"bytes"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path"
+)
+
+// newSftpClient starts the sftp subsystem on client. It returns nil when the
+// server refuses it or the sftp server fails to start, in which case file
+// operations fall back to shell commands.
+func newSftpClient(client *ssh.Client) *sftp.Client {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil
+	}
+	err = session.RequestSubsystem("sftp")
+	if err != nil {
+		_ = session.Close()
+		return nil
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil
+	}
+	sftpClient, err := sftp.NewClientPipe(r, w)
+	if err != nil {
+		_ = session.Close()
+		return nil
+	}
+	return sftpClient
+}
+
+func sftpExists(client *sftp.Client, name string) (bool, error) {
+	_, err := client.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func sftpReadFile(client *sftp.Client, fileName string) ([]byte, error) {
+	file, err := client.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := &bytes.Buffer{}
+	_, err = file.WriteTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func sftpReadDir(client *sftp.Client, dir string) ([]FileInfo, error) {
+	infos, err := client.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	owners := sftpOwners(client)
+	files := make([]FileInfo, len(infos))
+	for i, info := range infos {
+		files[i], err = sftpFileInfo(client, path.Join(dir, info.Name()), info, owners)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// sftpStat reads name with stat, which is Stat or Lstat of the sftp client.
+func sftpStat(client *sftp.Client, name string, stat func(name string) (os.FileInfo, error)) (FileInfo, error) {
+	info, err := stat(name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return sftpFileInfo(client, name, info, sftpOwners(client))
+}
+
+func sftpFileInfo(client *sftp.Client, filePath string, info os.FileInfo, owners *ownerLookup) (FileInfo, error) {
+	file := newFileInfo(filePath, info)
+	if stat, ok := info.Sys().(*sftp.FileStat); ok {
+		file.owner, file.group = owners.user(stat.UID), owners.group(stat.GID)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := client.ReadLink(filePath)
+		if err != nil {
+			return FileInfo{}, err
+		}
+		file.link = link
+	}
+	return file, nil
+}
+
+// sftpOwners resolves ids with /etc/passwd and /etc/group of the remote host,
+// sftp only transfers the ids.
+func sftpOwners(client *sftp.Client) *ownerLookup {
+	return newOwnerLookup(sftpIDNames(client, "/etc/passwd"), sftpIDNames(client, "/etc/group"))
+}
+
+// sftpIDNames returns a lookup in name, which is read when first needed.
+func sftpIDNames(client *sftp.Client, name string) lookupFunc {
+	var names map[string]string
+	return func(id string) (string, error) {
+		if names == nil {
+			data, err := sftpReadFile(client, name)
+			if err != nil {
+				names = make(map[string]string)
+				return "", err
+			}
+			names = idNames(data)
+		}
+		return names[id], nil
+	}
+}
+
+func sftpMakeDirAll(client *sftp.Client, dir string, perm os.FileMode) error {
+	err := client.MkdirAll(dir)
+	if err != nil {
+		return err
+	}
+	return client.Chmod(dir, perm)
+}
+
+func sftpRemove(client *sftp.Client, name string) error {
+	err := client.Remove(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// sftpRemoveAll removes name and everything it contains, depth first.
+func sftpRemoveAll(client *sftp.Client, name string) error {
+	info, err := client.Lstat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return sftpRemove(client, name)
+	}
+	children, err := client.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		err = sftpRemoveAll(client, path.Join(name, child.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	err = client.RemoveDirectory(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func sftpCreate(client *sftp.Client, name string) error {
+	err := client.MkdirAll(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	file, err := client.OpenFile(name, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func sftpWriteString(client *sftp.Client, name string, data string, mode ...string) error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	appendMode := len(mode) == 1 && mode[0] == ">>"
+	if appendMode {
+		flag = os.O_WRONLY | os.O_CREATE
+	}
+	file, err := client.OpenFile(name, flag)
+	if err != nil {
+		return err
+	}
+	if appendMode {
+		_, err = file.Seek(0, io.SeekEnd)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	_, err = io.WriteString(file, data)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
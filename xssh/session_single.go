@@ -1,6 +1,8 @@
 package xssh
 
 import (
+	"context"
+	"io"
 	"os"
 	"sync"
 )
@@ -15,9 +17,9 @@ func NewSingleSession(config Config) (Session, error) {
 		Lock: &sync.Mutex{},
 	}
 	if config.IsLocal() {
-		session.session = &LocalSession{}
+		session.session = &LocalSession{Config: config}
 	} else {
-		session.session = &RemoteSession{}
+		session.session = &RemoteSession{Config: config}
 	}
 	err := session.Connect()
 	if err != nil {
@@ -56,13 +58,40 @@ func (c SingleSession) CombinedOutput(name string, arg ...string) ([]byte, error
 	return c.session.CombinedOutput(name, arg...)
 }
 
-func (c SingleSession) OutputGrep(cmdList []struct {
-	name string
-	arg  []string
-}) ([]byte, error) {
+func (c SingleSession) RunContext(ctx context.Context, name string, arg ...string) error {
 	c.Lock.Lock()
 	defer c.Lock.Unlock()
-	return c.session.OutputGrep(cmdList)
+	return c.session.RunContext(ctx, name, arg...)
+}
+
+func (c SingleSession) OutputContext(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.OutputContext(ctx, name, arg...)
+}
+
+func (c SingleSession) CombinedOutputContext(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.CombinedOutputContext(ctx, name, arg...)
+}
+
+func (c SingleSession) Exec(ctx context.Context, cmd *Cmd) (*Result, error) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.Exec(ctx, cmd)
+}
+
+func (c SingleSession) Start(ctx context.Context, cmd *Cmd) (Process, error) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.Start(ctx, cmd)
+}
+
+func (c SingleSession) Shell(ctx context.Context, opts TerminalOptions) (Terminal, error) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.Shell(ctx, opts)
 }
 
 func (c SingleSession) Exists(path string) (bool, error) {
@@ -83,6 +112,24 @@ func (c SingleSession) ReadDir(dir string) ([]FileInfo, error) {
 	return c.session.ReadDir(dir)
 }
 
+func (c SingleSession) Platform() (Platform, error) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.Platform()
+}
+
+func (c SingleSession) Stat(name string) (FileInfo, error) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.Stat(name)
+}
+
+func (c SingleSession) Lstat(name string) (FileInfo, error) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.Lstat(name)
+}
+
 func (c SingleSession) MakeDirAll(path string, perm os.FileMode) error {
 	c.Lock.Lock()
 	defer c.Lock.Unlock()
@@ -112,3 +159,31 @@ func (c SingleSession) WriteString(name string, data string, mode ...string) err
 	defer c.Lock.Unlock()
 	return c.session.WriteString(name, data, mode...)
 }
+
+func (c SingleSession) Upload(ctx context.Context, localPath string, remotePath string, opts TransferOptions) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.Upload(ctx, localPath, remotePath, opts)
+}
+
+func (c SingleSession) Download(ctx context.Context, remotePath string, localPath string, opts TransferOptions) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.Download(ctx, remotePath, localPath, opts)
+}
+
+func (c SingleSession) UploadFrom(ctx context.Context, r io.Reader, remotePath string, opts TransferOptions) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.UploadFrom(ctx, r, remotePath, opts)
+}
+
+func (c SingleSession) DownloadTo(ctx context.Context, remotePath string, w io.Writer, opts TransferOptions) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.session.DownloadTo(ctx, remotePath, w, opts)
+}
+
+func (c SingleSession) Sudo() Session {
+	return SingleSession{Lock: c.Lock, session: c.session.Sudo()}
+}
@@ -1,22 +1,36 @@
 package xssh
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 )
 
 func TestSingleSession_Exists(t *testing.T) {
-	singleSession, err := NewSingleSession(Config{})
+	_, config := startTestServer(t)
+	singleSession, err := NewSingleSession(config)
 	if err != nil {
 		t.Fatal(err)
 	}
-	exists, err := singleSession.Exists("D:\\iso\\H3C_UIS-E0770-centos-x86_64-AUTO.iso")
+	defer singleSession.Close()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(path, []byte("data"), 0644)
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Log(exists)
-	exists, err = singleSession.Exists("D:\\iso\\H3C_UIS-E0770-centos-x86_64-AUTO")
+	exists, err := singleSession.Exists(path)
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Log(exists)
+	if !exists {
+		t.Fatalf("expected %s to exist", path)
+	}
+	exists, err = singleSession.Exists(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected the missing file not to exist")
+	}
 }
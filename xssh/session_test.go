@@ -1,16 +1,220 @@
 package xssh
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/candbright/util/xssh/sshtest"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestServer starts an ssh server on this machine and returns the config
+// to connect to it as a remote host, its key in a known_hosts file of its own.
+func startTestServer(t *testing.T) (*sshtest.Server, Config) {
+	server := sshtest.NewServer()
+	t.Cleanup(server.Close)
+	config := NewConfig(false, "localhost", sshtest.DefaultUser, sshtest.DefaultPassword, server.Port())
+	config.SetKnownHostsFile(filepath.Join(t.TempDir(), "known_hosts"))
+	err := NewKnownHosts(config.KnownHostsFile()).Add(fmt.Sprintf("localhost:%d", server.Port()), server.HostKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, config
+}
 
 func TestRemoteSession_Exists(t *testing.T) {
-	session := LocalSession{}
-	output, err := session.Output("cmd", "/c", "dir", "/b", "D:\\iso\\H3C_UIS-E0770-centos-x86_64-AUTO.iso")
+	if runtime.GOOS != "linux" {
+		t.Skip("the test server runs commands with sh")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	err := ioutil.WriteFile(path, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, useSftp := range []bool{true, false} {
+		server, config := startTestServer(t)
+		server.SetSftp(useSftp)
+		session := &RemoteSession{Config: config}
+		err = session.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (session.sftpClient() != nil) != useSftp {
+			t.Fatalf("sftp %v: unexpected client %v", useSftp, session.Sftp)
+		}
+		exists, err := session.Exists(path)
+		if err != nil || !exists {
+			t.Errorf("sftp %v: expected %s to exist, got %v %v", useSftp, path, exists, err)
+		}
+		exists, err = session.Exists(filepath.Join(dir, "it's missing"))
+		if err != nil || exists {
+			t.Errorf("sftp %v: expected the missing file not to exist, got %v %v", useSftp, exists, err)
+		}
+		_ = session.Close()
+	}
+}
+
+func TestRemoteSession_ShellPlatforms(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the test server runs commands with sh")
+	}
+	session := &RemoteSession{Config: startExecServer(t)}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	//a POSIX shell is enough, whatever the system
+	session.platform = &Platform{Family: FamilyDarwin}
+	name := filepath.Join(t.TempDir(), "dir", "file")
+	err = session.MakeDirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.WriteString(name, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil || string(data) != "data" {
+		t.Fatalf("unexpected content %q %v", data, err)
+	}
+	session.platform = &Platform{Family: FamilyWindows}
+	err = session.RemoveAll(name)
+	if !errors.Is(err, ErrUnsupportedPlatform) {
+		t.Fatalf("expected ErrUnsupportedPlatform, got %v", err)
+	}
+}
+
+func TestRemoteSession_ShellDarwin(t *testing.T) {
+	server, config := startTestServer(t)
+	server.SetSftp(false)
+	server.SetFallback(func(e *sshtest.Exec) int {
+		switch {
+		case strings.Contains(e.Command, " -c "):
+			_, _ = io.WriteString(e.Stderr, "stat: illegal option -- c\n")
+			return 1
+		case strings.HasPrefix(e.Command, "find /etc/ "):
+			return sshtest.Output("81A4|5|1600000000|root|wheel|/etc/hosts\nA1ED|11|1600000000|root|wheel|/etc/localtime\n", 0)(e)
+		case strings.HasPrefix(e.Command, "stat -L -f "):
+			return sshtest.Output("81A4|5|1600000000|root|wheel|/etc/hosts\n", 0)(e)
+		case strings.HasPrefix(e.Command, "readlink -- /etc/localtime"):
+			return sshtest.Output("/var/db/timezone/zoneinfo/UTC\n", 0)(e)
+		default:
+			return sshtest.NotFound(e)
+		}
+	})
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	session.platform = &Platform{Family: FamilyDarwin}
+	info, err := session.Stat("/etc/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "hosts" || info.Size() != 5 || info.Mode() != 0644 || info.ModTime().Unix() != 1600000000 || info.Group() != "wheel" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	files, err := session.ReadDir("/etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[1].Name() != "localtime" || files[1].LinkTarget() != "/var/db/timezone/zoneinfo/UTC" {
+		t.Fatalf("unexpected entries %+v", files)
+	}
+}
+
+func TestRemoteSession_UnknownPlatform(t *testing.T) {
+	server, config := startTestServer(t)
+	server.SetSftp(false)
+	server.SetFilesystem(sshtest.FilesystemMemory)
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	//no command is found, the platform cannot be probed
+	err = session.WriteString("/file", "data")
+	if err == nil {
+		t.Fatal("expected the failed probe to be reported")
+	}
+}
+
+func TestLocalSession_RunContext(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires sleep")
+	}
+	config := Config{}
+	config.SetCommandTimeout(100 * time.Millisecond)
+	session := LocalSession{Config: config}
+	start := time.Now()
+	err := session.Run("sleep", "5")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("command was not cancelled")
+	}
+}
+
+func TestRemoteSession_RunContext(t *testing.T) {
+	server, config := startTestServer(t)
+	signals := make(chan string, 8)
+	server.Handle("sleep 60", func(e *sshtest.Exec) int {
+		for name := range e.Signals {
+			signals <- name
+		}
+		//the client closed the session
+		signals <- "closed"
+		return 137
+	})
+	wantKilled := func() {
+		if name := <-signals; name != "KILL" {
+			t.Fatalf("want KILL, got %s", name)
+		}
+		for name := range signals {
+			if name == "closed" {
+				return
+			}
+		}
+	}
+
+	config.SetCommandTimeout(50 * time.Millisecond)
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	err = session.Run("sleep", "60")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+	wantKilled()
+
+	config.SetCommandTimeout(0)
+	session = &RemoteSession{Config: config}
+	err = session.Connect()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(output) != 0 {
-		t.Log("true")
-	} else {
-		t.Log("false")
+	defer session.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = session.OutputContext(ctx, "sleep", "60")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want %v, got %v", context.Canceled, err)
 	}
+	wantKilled()
 }
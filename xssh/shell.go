@@ -0,0 +1,226 @@
+package xssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type execFunc func(ctx context.Context, cmd *Cmd, stdout io.Writer, stderr io.Writer) (*Result, error)
+
+// shellFS implements the Session file operations with POSIX shell commands.
+type shellFS struct {
+	exec execFunc
+	//bsd selects the stat(1) of BSD and macOS instead of GNU coreutils
+	bsd bool
+}
+
+func (f shellFS) run(cmd *Cmd) error {
+	_, err := f.exec(context.Background(), cmd, nil, &bytes.Buffer{})
+	return err
+}
+
+func (f shellFS) output(cmd *Cmd) ([]byte, error) {
+	result, err := f.exec(context.Background(), cmd, &bytes.Buffer{}, &bytes.Buffer{})
+	if err != nil {
+		return nil, err
+	}
+	return result.Stdout, nil
+}
+
+func (f shellFS) Exists(name string) (bool, error) {
+	output, err := f.output(NewCmd("test", "-e", name).Raw("&&", "echo", "true", "||", "echo", "false"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(output)) == "true", nil
+}
+
+func (f shellFS) ReadFile(fileName string) ([]byte, error) {
+	return f.output(NewCmd("cat", "--", fileName))
+}
+
+// ReadDir runs stat(1) on the entries of dir through find(1), which passes
+// them in batches fitting the argument size limit.
+func (f shellFS) ReadDir(dir string) ([]FileInfo, error) {
+	//the trailing slash follows a symbolic link to a directory, and keeps a
+	//name starting with - from being read as an option
+	start := strings.TrimSuffix(dir, "/") + "/"
+	if strings.HasPrefix(start, "-") {
+		start = "./" + start
+	}
+	cmd := NewCmd("find", start, "-mindepth", "1", "-maxdepth", "1", "-exec", "stat").Arg(f.statArgs()...).Raw("{}", "+")
+	output, err := f.output(cmd)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0)
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" {
+			continue
+		}
+		file, err := parseStat(line)
+		if err != nil {
+			return nil, err
+		}
+		file.path = path.Join(dir, file.name)
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	err = f.readLinks(files)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (f shellFS) Stat(name string) (FileInfo, error) {
+	return f.stat(true, name)
+}
+
+func (f shellFS) Lstat(name string) (FileInfo, error) {
+	return f.stat(false, name)
+}
+
+// statFormat prints the raw mode in hex, the size, the modification time, the
+// owner, the group and the name.
+const statFormat = "%f|%s|%Y|%U|%G|%n"
+
+// bsdStatFormat prints the fields of statFormat with the stat(1) of BSD and macOS.
+const bsdStatFormat = "%Xp|%z|%m|%Su|%Sg|%N"
+
+// statArgs returns the options of stat(1) printing statFormat.
+func (f shellFS) statArgs() []string {
+	if f.bsd {
+		return []string{"-f", bsdStatFormat}
+	}
+	return []string{"-c", statFormat}
+}
+
+// readlinkBatch is the number of links resolved by one readlink(1).
+const readlinkBatch = 256
+
+// stat runs stat(1) on name, following symbolic links when follow is set.
+func (f shellFS) stat(follow bool, name string) (FileInfo, error) {
+	cmd := NewCmd("stat")
+	if follow {
+		cmd.Arg("-L")
+	}
+	result, err := f.exec(context.Background(), cmd.Arg(f.statArgs()...).Arg("--", name), &bytes.Buffer{}, &bytes.Buffer{})
+	if err != nil {
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "No such file") {
+			return FileInfo{}, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+		}
+		return FileInfo{}, err
+	}
+	file, err := parseStat(strings.TrimSuffix(string(result.Stdout), "\n"))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	file.name = path.Base(name)
+	file.path = name
+	files := []FileInfo{file}
+	err = f.readLinks(files)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return files[0], nil
+}
+
+// readLinks sets the targets of the symbolic links among files.
+func (f shellFS) readLinks(files []FileInfo) error {
+	links := make([]int, 0)
+	for i := range files {
+		if files[i].mode&os.ModeSymlink != 0 {
+			links = append(links, i)
+		}
+	}
+	for len(links) != 0 {
+		batch := links
+		if len(batch) > readlinkBatch {
+			batch = batch[:readlinkBatch]
+		}
+		links = links[len(batch):]
+		cmd := NewCmd("readlink", "--")
+		for _, i := range batch {
+			cmd.Arg(files[i].path)
+		}
+		output, err := f.output(cmd)
+		if err != nil {
+			return err
+		}
+		targets := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+		if len(targets) != len(batch) {
+			return fmt.Errorf("unexpected output of readlink: %q", output)
+		}
+		for j, i := range batch {
+			files[i].link = targets[j]
+		}
+	}
+	return nil
+}
+
+// parseStat parses a line printed with statFormat. The name may contain the
+// separator, it comes last.
+func parseStat(line string) (FileInfo, error) {
+	fields := strings.SplitN(line, "|", 6)
+	if len(fields) != 6 {
+		return FileInfo{}, fmt.Errorf("unexpected output of stat: %q", line)
+	}
+	mode, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("unexpected output of stat: %q", line)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("unexpected output of stat: %q", line)
+	}
+	mtime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("unexpected output of stat: %q", line)
+	}
+	return FileInfo{
+		name:    path.Base(fields[5]),
+		path:    fields[5],
+		size:    size,
+		mode:    unixFileMode(uint32(mode)),
+		modTime: time.Unix(mtime, 0),
+		owner:   fields[3],
+		group:   fields[4],
+	}, nil
+}
+
+func (f shellFS) MakeDirAll(dir string, perm os.FileMode) error {
+	return f.run(NewCmd("mkdir", "-p", "-m", strconv.FormatUint(uint64(perm.Perm()), 8), "--", dir))
+}
+
+func (f shellFS) Remove(name string) error {
+	return f.run(NewCmd("rm", "-f", "--", name))
+}
+
+func (f shellFS) RemoveAll(name string) error {
+	return f.run(NewCmd("rm", "-r", "-f", "--", name))
+}
+
+func (f shellFS) Create(name string) error {
+	return f.run(NewCmd("mkdir", "-p", "--", path.Dir(name)).Raw("&&").Arg("touch", "--", name))
+}
+
+func (f shellFS) WriteString(name string, data string, mode ...string) error {
+	flag := ">"
+	if len(mode) == 1 && mode[0] == ">>" {
+		flag = ">>"
+	}
+	return f.run(NewCmd("cat").Raw(flag).Arg(name).Stdin(strings.NewReader(data)))
+}
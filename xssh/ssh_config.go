@@ -0,0 +1,553 @@
+package xssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSSHConfigSyntax = errors.New("ssh config syntax error")
+)
+
+// SystemSSHConfigFile is read after the file of the user by LoadDefaultSSHConfig.
+const SystemSSHConfigFile = "/etc/ssh/ssh_config"
+
+const (
+	maxSSHConfigInclude = 16
+	maxSSHConfigJumps   = 16
+)
+
+// SSHConfig is an OpenSSH client configuration, see ssh_config(5). Host and
+// Match blocks are applied in order and the first value of an option wins,
+// except for IdentityFile whose values add up.
+type SSHConfig struct {
+	blocks []sshConfigBlock
+}
+
+// sshConfigBlock holds the options following a Host or a Match line, or
+// preceding the first one when both hosts and criteria are nil.
+type sshConfigBlock struct {
+	hosts    []string
+	criteria []string
+	options  []sshConfigOption
+}
+
+type sshConfigOption struct {
+	key  string
+	args []string
+}
+
+func DefaultSSHConfigFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".ssh", "config")
+	}
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// LoadSSHConfig reads the configuration file path. Included files without an
+// absolute path are looked up in ~/.ssh.
+func LoadSSHConfig(path string) (*SSHConfig, error) {
+	c := &SSHConfig{}
+	err := c.read(path, userSSHDir(), 0, sshConfigBlock{})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadDefaultSSHConfig reads the configuration files of the OpenSSH client:
+// DefaultSSHConfigFile, then SystemSSHConfigFile. Missing files are skipped.
+func LoadDefaultSSHConfig() (*SSHConfig, error) {
+	c := &SSHConfig{}
+	files := []struct{ path, includeDir string }{
+		{DefaultSSHConfigFile(), userSSHDir()},
+		{SystemSSHConfigFile, filepath.Dir(SystemSSHConfigFile)},
+	}
+	for _, file := range files {
+		if !Exists(file.path) {
+			continue
+		}
+		err := c.read(file.path, file.includeDir, 0, sshConfigBlock{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func userSSHDir() string {
+	return filepath.Dir(DefaultSSHConfigFile())
+}
+
+// read appends the blocks of the file path. Its first options belong to the
+// block it is included from, an unconditional one for the top level files.
+func (c *SSHConfig) read(path string, includeDir string, depth int, block sshConfigBlock) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	block.options = nil
+	c.blocks = append(c.blocks, block)
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		key, args, err := parseSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		switch key {
+		case "":
+		case "host":
+			if len(args) == 0 {
+				return fmt.Errorf("%s:%d: %w: Host without patterns", path, line, ErrSSHConfigSyntax)
+			}
+			c.blocks = append(c.blocks, sshConfigBlock{hosts: args})
+		case "match":
+			err = checkMatchCriteria(args)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			c.blocks = append(c.blocks, sshConfigBlock{criteria: args})
+		case "include":
+			if depth >= maxSSHConfigInclude {
+				return fmt.Errorf("%s:%d: too many nested includes", path, line)
+			}
+			err = c.include(args, includeDir, depth)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+		default:
+			last := &c.blocks[len(c.blocks)-1]
+			last.options = append(last.options, sshConfigOption{key: key, args: args})
+		}
+	}
+	return scanner.Err()
+}
+
+// include reads the files matching patterns. The options following the
+// Include line keep applying to the block it appears in.
+func (c *SSHConfig) include(patterns []string, includeDir string, depth int) error {
+	current := c.blocks[len(c.blocks)-1]
+	for _, pattern := range patterns {
+		pattern = expandHome(pattern)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(includeDir, pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			err = c.read(file, includeDir, depth+1, current)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	current.options = nil
+	c.blocks = append(c.blocks, current)
+	return nil
+}
+
+// parseSSHConfigLine splits a line into its lower case keyword and its
+// arguments, which are separated by spaces or an equal sign and may be
+// double quoted. Blank lines and comments return an empty keyword.
+func parseSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return "", nil, fmt.Errorf("%w: missing argument to %s", ErrSSHConfigSyntax, line)
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimSpace(line[end:])
+	if strings.HasPrefix(rest, "=") {
+		rest = strings.TrimSpace(rest[1:])
+	}
+	var args []string
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return "", nil, fmt.Errorf("%w: unterminated quote", ErrSSHConfigSyntax)
+			}
+			arg, rest = rest[1:closing+1], rest[closing+2:]
+		} else {
+			end = strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			arg, rest = rest[:end], rest[end:]
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("%w: missing argument to %s", ErrSSHConfigSyntax, key)
+	}
+	return key, args, nil
+}
+
+// checkMatchCriteria rejects the criteria of a Match line which cannot be
+// evaluated: exec, localnetwork and tagged.
+func checkMatchCriteria(args []string) error {
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(strings.TrimPrefix(args[i], "!"))
+		switch criterion {
+		case "all", "canonical", "final":
+		case "host", "originalhost", "user", "localuser":
+			if i+1 == len(args) {
+				return fmt.Errorf("%w: Match %s without patterns", ErrSSHConfigSyntax, criterion)
+			}
+			i++
+		default:
+			return fmt.Errorf("%w: unsupported Match criterion %s", ErrSSHConfigSyntax, args[i])
+		}
+	}
+	return nil
+}
+
+// Hosts returns the aliases of the Host lines without wildcards or negation.
+func (c *SSHConfig) Hosts() []string {
+	seen := make(map[string]bool)
+	hosts := make([]string, 0)
+	for _, block := range c.blocks {
+		for _, host := range block.hosts {
+			if strings.ContainsAny(host, "*?!") || seen[host] {
+				continue
+			}
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// sshHostOptions are the options resolved for a host, by lower case keyword.
+type sshHostOptions map[string][]string
+
+func (o sshHostOptions) get(key string) string {
+	if args, ok := o[key]; ok {
+		return args[0]
+	}
+	return ""
+}
+
+func (c *SSHConfig) resolve(alias string) sshHostOptions {
+	options := make(sshHostOptions)
+	localUser := localUserName()
+	for _, block := range c.blocks {
+		if !block.matches(alias, options, localUser) {
+			continue
+		}
+		for _, option := range block.options {
+			if option.key == "identityfile" {
+				options[option.key] = append(options[option.key], option.args...)
+			} else if _, ok := options[option.key]; !ok {
+				options[option.key] = option.args
+			}
+		}
+	}
+	return options
+}
+
+func (b sshConfigBlock) matches(alias string, options sshHostOptions, localUser string) bool {
+	if b.hosts != nil {
+		return matchPatternList(b.hosts, alias)
+	}
+	for i := 0; i < len(b.criteria); i++ {
+		criterion := strings.ToLower(b.criteria[i])
+		negate := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+		var ok bool
+		switch criterion {
+		case "all", "final":
+			ok = true
+		case "canonical":
+			//hostnames are never canonicalized
+			ok = false
+		default:
+			i++
+			patterns := strings.Split(b.criteria[i], ",")
+			switch criterion {
+			case "host":
+				ok = matchPatternList(patterns, expandHostName(options.get("hostname"), alias))
+			case "originalhost":
+				ok = matchPatternList(patterns, alias)
+			case "user":
+				remoteUser := options.get("user")
+				if remoteUser == "" {
+					remoteUser = localUser
+				}
+				ok = matchPatternList(patterns, remoteUser)
+			case "localuser":
+				ok = matchPatternList(patterns, localUser)
+			}
+		}
+		if ok == negate {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPatternList reports whether s matches one of patterns and none of the
+// negated ones, which start with "!". Hosts are compared case-insensitively.
+func matchPatternList(patterns []string, s string) bool {
+	s = strings.ToLower(s)
+	matched := false
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "!") {
+			if matchPattern(pattern[1:], s) {
+				return false
+			}
+		} else if matchPattern(pattern, s) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPattern matches s against pattern, where "*" matches any sequence of
+// characters and "?" any single character.
+func matchPattern(pattern string, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+func localUserName() string {
+	current, err := user.Current()
+	if err != nil {
+		return os.Getenv("USER")
+	}
+	//DOMAIN\user on Windows
+	return current.Username[strings.LastIndex(current.Username, `\`)+1:]
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+func expandHostName(hostname string, alias string) string {
+	if hostname == "" {
+		return alias
+	}
+	expanded, err := expandTokens(hostname, map[byte]string{'h': alias})
+	if err != nil {
+		return hostname
+	}
+	return expanded
+}
+
+// expandTokens replaces the %x tokens of s with tokens[x] and "%%" with "%".
+func expandTokens(s string, tokens map[byte]string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("%w: incomplete token in %q", ErrSSHConfigSyntax, s)
+		}
+		if s[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		value, ok := tokens[s[i]]
+		if !ok {
+			return "", fmt.Errorf("%w: unsupported token %%%c in %q", ErrSSHConfigSyntax, s[i], s)
+		}
+		b.WriteString(value)
+	}
+	return b.String(), nil
+}
+
+// Config returns the config connecting to alias the way ssh(1) would. It sets
+// the host, the user, which defaults to the local user, the port, the identity
+// files, with ssh-agent first unless IdentitiesOnly is set, the jump hosts of
+// ProxyJump, resolved through the configuration as well, the keepalive of
+// ServerAliveInterval and ServerAliveCountMax, and the host key policy of
+// StrictHostKeyChecking and UserKnownHostsFile: "yes" and "ask", the default,
+// are strict, "accept-new" trusts on first use and "no" accepts any key.
+// Other options are ignored.
+func (c *SSHConfig) Config(alias string) (Config, error) {
+	return c.config(alias, 0)
+}
+
+func (c *SSHConfig) config(alias string, depth int) (Config, error) {
+	options := c.resolve(alias)
+	host := expandHostName(options.get("hostname"), alias)
+	remoteUser := options.get("user")
+	localUser := localUserName()
+	if remoteUser == "" {
+		remoteUser = localUser
+	}
+	var port uint16 = 22
+	if value := options.get("port"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || n == 0 {
+			return Config{}, fmt.Errorf("%s: bad Port %q", alias, value)
+		}
+		port = uint16(n)
+	}
+	config := NewConfig(false, host, remoteUser, "", port)
+	home, _ := os.UserHomeDir()
+	hostname, _ := os.Hostname()
+	tokens := map[byte]string{
+		'd': home,
+		'h': host,
+		'L': strings.SplitN(hostname, ".", 2)[0],
+		'l': hostname,
+		'n': alias,
+		'p': strconv.Itoa(int(port)),
+		'r': remoteUser,
+		'u': localUser,
+	}
+	if files := options["identityfile"]; len(files) != 0 {
+		if !strings.EqualFold(options.get("identitiesonly"), "yes") && os.Getenv("SSH_AUTH_SOCK") != "" {
+			config.AddAuth(AgentAuth())
+		}
+		for _, file := range files {
+			path, err := expandTokens(file, tokens)
+			if err != nil {
+				return Config{}, fmt.Errorf("%s: IdentityFile: %w", alias, err)
+			}
+			config.AddIdentityFile(expandHome(path))
+		}
+	}
+	err := c.setJumpHosts(&config, alias, options.get("proxyjump"), depth)
+	if err != nil {
+		return Config{}, err
+	}
+	if value := options.get("serveraliveinterval"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return Config{}, fmt.Errorf("%s: bad ServerAliveInterval %q", alias, value)
+		}
+		maxMissed := 0
+		if value = options.get("serveralivecountmax"); value != "" {
+			maxMissed, err = strconv.Atoi(value)
+			if err != nil || maxMissed < 0 {
+				return Config{}, fmt.Errorf("%s: bad ServerAliveCountMax %q", alias, value)
+			}
+		}
+		config.SetKeepAlive(time.Duration(seconds)*time.Second, maxMissed)
+	}
+	switch value := strings.ToLower(options.get("stricthostkeychecking")); value {
+	case "", "yes", "ask":
+		config.SetHostKeyPolicy(HostKeyStrict)
+	case "accept-new":
+		config.SetHostKeyPolicy(HostKeyTrustOnFirstUse)
+	case "no", "off":
+		config.SetHostKeyPolicy(HostKeyInsecure)
+	default:
+		return Config{}, fmt.Errorf("%s: bad StrictHostKeyChecking %q", alias, value)
+	}
+	if value := options.get("userknownhostsfile"); value != "" && value != "none" {
+		path, err := expandTokens(value, tokens)
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: UserKnownHostsFile: %w", alias, err)
+		}
+		config.SetKnownHostsFile(expandHome(path))
+	}
+	return config, nil
+}
+
+// setJumpHosts adds the hosts of a ProxyJump value, "[user@]host[:port]"
+// separated by commas, each preceded by its own jump hosts.
+func (c *SSHConfig) setJumpHosts(config *Config, alias string, proxyJump string, depth int) error {
+	if proxyJump == "" || strings.EqualFold(proxyJump, "none") {
+		return nil
+	}
+	if depth >= maxSSHConfigJumps {
+		return fmt.Errorf("%s: too many nested ProxyJump hosts", alias)
+	}
+	for _, jump := range strings.Split(proxyJump, ",") {
+		jumpUser, jumpHost, jumpPort, err := parseJumpHost(jump)
+		if err != nil {
+			return fmt.Errorf("%s: bad ProxyJump %q: %w", alias, jump, err)
+		}
+		jumpConfig, err := c.config(jumpHost, depth+1)
+		if err != nil {
+			return err
+		}
+		if jumpUser != "" {
+			jumpConfig.user = jumpUser
+		}
+		if jumpPort != 0 {
+			jumpConfig.port = jumpPort
+		}
+		config.AddJumpHost(jumpConfig.jumpHosts...)
+		jumpConfig.jumpHosts = nil
+		config.AddJumpHost(jumpConfig)
+	}
+	return nil
+}
+
+func parseJumpHost(jump string) (string, string, uint16, error) {
+	jump = strings.TrimPrefix(strings.TrimSpace(jump), "ssh://")
+	jumpUser := ""
+	if at := strings.LastIndex(jump, "@"); at >= 0 {
+		jumpUser, jump = jump[:at], jump[at+1:]
+	}
+	host := jump
+	var port uint16
+	if strings.HasPrefix(jump, "[") || strings.Count(jump, ":") == 1 {
+		h, p, err := net.SplitHostPort(jump)
+		if err != nil {
+			return "", "", 0, err
+		}
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || n == 0 {
+			return "", "", 0, fmt.Errorf("invalid port %q", p)
+		}
+		host, port = h, uint16(n)
+	}
+	if host == "" {
+		return "", "", 0, errors.New("missing host")
+	}
+	return jumpUser, host, port, nil
+}
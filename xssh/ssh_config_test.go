@@ -0,0 +1,164 @@
+package xssh
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func writeSSHConfig(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSSHConfig_Config(t *testing.T) {
+	dir := t.TempDir()
+	writeSSHConfig(t, dir, "extra.conf", `
+Port 2200
+Host db
+    User dba
+`)
+	path := writeSSHConfig(t, dir, "config", `
+# bastions
+Host bastion
+    HostName bastion.example.com
+    User jump
+    Port=2222
+
+Host inner
+    ProxyJump bastion
+
+Host web* !web-test
+    HostName %h.example.com
+    IdentityFile "`+dir+`/id %r@%h"
+    IdentitiesOnly yes
+    ServerAliveInterval 15
+    StrictHostKeyChecking accept-new
+
+Host db
+    HostName 10.0.0.5
+    ProxyJump ops@inner:2022,bastion
+    Include `+filepath.Join(dir, "*.conf")+`
+    UserKnownHostsFile `+dir+`/known_hosts
+
+Match originalhost db user dba
+    StrictHostKeyChecking no
+
+Match host *.example.com
+    IdentityFile `+dir+`/shared
+    User deploy
+
+Host *
+    User nobody
+    ServerAliveCountMax 5
+`)
+	sshConfig, err := LoadSSHConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := sshConfig.Hosts()
+	if len(hosts) != 3 || hosts[0] != "bastion" || hosts[2] != "db" {
+		t.Fatalf("unexpected hosts %q", hosts)
+	}
+
+	web, err := sshConfig.Config("web1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if web.Host() != "web1.example.com" || web.User() != "deploy" || web.Port() != 22 || web.IsLocal() {
+		t.Fatalf("unexpected web config %s@%s:%d", web.User(), web.Host(), web.Port())
+	}
+	if len(web.AuthMethods()) != 2 {
+		t.Fatalf("expected two identity files, got %d auth methods", len(web.AuthMethods()))
+	}
+	if file := web.AuthMethods()[0].(*keyFileAuth).path; file != dir+"/id deploy@web1.example.com" {
+		t.Fatalf("unexpected identity file %s", file)
+	}
+	if web.KeepAliveInterval() != 15*time.Second || web.KeepAliveMaxMissed() != 5 || web.HostKeyPolicy() != HostKeyTrustOnFirstUse {
+		t.Fatalf("unexpected keepalive %v %d or policy %v", web.KeepAliveInterval(), web.KeepAliveMaxMissed(), web.HostKeyPolicy())
+	}
+
+	test, err := sshConfig.Config("web-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if test.Host() != "web-test" || test.User() != "nobody" {
+		t.Fatalf("unexpected negated host config %s@%s", test.User(), test.Host())
+	}
+	//without StrictHostKeyChecking, ssh asks, i.e. only accepts known keys
+	if test.HostKeyPolicy() != HostKeyStrict || test.KnownHostsFile() != DefaultKnownHostsFile() {
+		t.Fatalf("unexpected policy %v with %s", test.HostKeyPolicy(), test.KnownHostsFile())
+	}
+
+	db, err := sshConfig.Config("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Host() != "10.0.0.5" || db.User() != "dba" || db.Port() != 2200 || db.HostKeyPolicy() != HostKeyInsecure ||
+		db.KnownHostsFile() != dir+"/known_hosts" {
+		t.Fatalf("unexpected db config %s@%s:%d %v %s", db.User(), db.Host(), db.Port(), db.HostKeyPolicy(), db.KnownHostsFile())
+	}
+	jumps := db.JumpHosts()
+	want := []string{"jump@bastion.example.com:2222", "ops@inner:2022", "jump@bastion.example.com:2222"}
+	if len(jumps) != len(want) {
+		t.Fatalf("got %d jump hosts, want %d", len(jumps), len(want))
+	}
+	for i, jump := range jumps {
+		if got := jump.User() + "@" + jump.Host() + ":" + strconv.Itoa(int(jump.Port())); got != want[i] {
+			t.Errorf("jump host %d: got %s, want %s", i, got, want[i])
+		}
+		if len(jump.JumpHosts()) != 0 {
+			t.Errorf("jump host %d has nested jump hosts", i)
+		}
+	}
+}
+
+func TestSSHConfig_Errors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"exec":     "Match exec \"true\"\n",
+		"quote":    "Host a\n  HostName \"a\n",
+		"argument": "Host a\n  HostName\n",
+	} {
+		_, err := LoadSSHConfig(writeSSHConfig(t, dir, name, content))
+		if !errors.Is(err, ErrSSHConfigSyntax) {
+			t.Errorf("%s: expected a syntax error, got %v", name, err)
+		}
+	}
+	sshConfig, err := LoadSSHConfig(writeSSHConfig(t, dir, "loop", "Host a\n  ProxyJump b\nHost b\n  ProxyJump a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sshConfig.Config("a")
+	if err == nil {
+		t.Fatal("expected a ProxyJump loop to fail")
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"web?", "web1", true},
+		{"web?", "web10", false},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"10.0.*.1", "10.0.12.1", true},
+		{"a*b*c", "abxbc", true},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package sshtest
+
+import (
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"sync"
+)
+
+// remoteForwards holds the listeners opened by the tcpip-forward requests of a
+// connection, by "host:port".
+type remoteForwards struct {
+	lock      sync.Mutex
+	listeners map[string]net.Listener
+}
+
+func (f *remoteForwards) add(addr string, listener net.Listener) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.listeners[addr] = listener
+}
+
+func (f *remoteForwards) remove(addr string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	listener, ok := f.listeners[addr]
+	if ok {
+		_ = listener.Close()
+		delete(f.listeners, addr)
+	}
+	return ok
+}
+
+func (f *remoteForwards) closeAll() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for addr, listener := range f.listeners {
+		_ = listener.Close()
+		delete(f.listeners, addr)
+	}
+}
+
+type forwardRequest struct {
+	Addr string
+	Port uint32
+}
+
+func (s *Server) globalRequests(conn *ssh.ServerConn, reqs <-chan *ssh.Request, forwards *remoteForwards) {
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			var payload forwardRequest
+			if !s.forwardingEnabled() || ssh.Unmarshal(req.Payload, &payload) != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			listener, err := net.Listen("tcp", net.JoinHostPort(payload.Addr, portString(payload.Port)))
+			if err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			payload.Port = uint32(listener.Addr().(*net.TCPAddr).Port)
+			forwards.add(net.JoinHostPort(payload.Addr, portString(payload.Port)), listener)
+			go acceptForwarded(conn, listener, payload)
+			_ = req.Reply(true, ssh.Marshal(struct{ Port uint32 }{payload.Port}))
+		case "cancel-tcpip-forward":
+			var payload forwardRequest
+			ok := ssh.Unmarshal(req.Payload, &payload) == nil &&
+				forwards.remove(net.JoinHostPort(payload.Addr, portString(payload.Port)))
+			_ = req.Reply(ok, nil)
+		default:
+			//e.g. keepalive@openssh.com
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// acceptForwarded opens a forwarded-tcpip channel for every connection
+// accepted by listener.
+func acceptForwarded(conn *ssh.ServerConn, listener net.Listener, forward forwardRequest) {
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(c net.Conn) {
+			origin := c.RemoteAddr().(*net.TCPAddr)
+			ch, reqs, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+				Addr       string
+				Port       uint32
+				OriginAddr string
+				OriginPort uint32
+			}{forward.Addr, forward.Port, origin.IP.String(), uint32(origin.Port)}))
+			if err != nil {
+				_ = c.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			pipe(ch, c)
+		}(c)
+	}
+}
+
+func (s *Server) directTCPIP(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginAddr string
+		OriginPort uint32
+	}
+	if !s.forwardingEnabled() {
+		_ = newChannel.Reject(ssh.Prohibited, "port forwarding is disabled")
+		return
+	}
+	if ssh.Unmarshal(newChannel.ExtraData(), &payload) != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+	c, err := net.Dial("tcp", net.JoinHostPort(payload.Host, portString(payload.Port)))
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		_ = c.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	pipe(ch, c)
+}
+
+// pipe copies between ch and c in both directions until both ends are closed.
+func pipe(ch ssh.Channel, c net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(ch, c)
+		_ = ch.CloseWrite()
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(c, ch)
+		if tcp, ok := c.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+	_ = ch.Close()
+	_ = c.Close()
+}
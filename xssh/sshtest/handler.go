@@ -0,0 +1,112 @@
+package sshtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Exec is a command requested by a client.
+type Exec struct {
+	User    string
+	Command string
+	// Env holds the variables set by the client as "key=value".
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Signals receives the signals sent by the client, e.g. "KILL".
+	Signals <-chan string
+}
+
+// Handler runs a command and returns its exit status.
+type Handler func(exec *Exec) int
+
+// Output returns a handler which writes stdout and exits with status.
+func Output(stdout string, status int) Handler {
+	return func(exec *Exec) int {
+		_, _ = io.WriteString(exec.Stdout, stdout)
+		return status
+	}
+}
+
+// NotFound answers like a shell which does not know the command.
+func NotFound(exec *Exec) int {
+	_, _ = fmt.Fprintf(exec.Stderr, "sh: %s: command not found\n", strings.Fields(exec.Command + " ?")[0])
+	return 127
+}
+
+// Shell runs the command with sh on this machine, with the environment of the
+// test process and the variables set by the client.
+func Shell(e *Exec) int {
+	cmd := exec.Command("sh", "-c", e.Command)
+	cmd.Env = append(os.Environ(), e.Env...)
+	cmd.Stdout = e.Stdout
+	cmd.Stderr = e.Stderr
+	//the command must not wait for the client to close stdin
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 127
+	}
+	go func() {
+		_, _ = io.Copy(stdin, e.Stdin)
+		_ = stdin.Close()
+	}()
+	err = cmd.Start()
+	if err != nil {
+		_, _ = fmt.Fprintln(e.Stderr, err)
+		return 127
+	}
+	exited := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-exited:
+				return
+			case name, ok := <-e.Signals:
+				if !ok {
+					return
+				}
+				sendSignal(cmd.Process, name)
+			}
+		}
+	}()
+	err = cmd.Wait()
+	close(exited)
+	return exitStatus(err)
+}
+
+var signals = map[string]os.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+}
+
+func sendSignal(process *os.Process, name string) {
+	sig, ok := signals[name]
+	if ok {
+		_ = process.Signal(sig)
+	}
+}
+
+// exitStatus returns the status of a command, 128 plus the signal number as
+// shells do when it was killed.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 127
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
@@ -0,0 +1,305 @@
+// Package sshtest provides an in-process ssh server for testing ssh clients
+// without a real machine.
+package sshtest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strconv"
+	"sync"
+)
+
+const (
+	DefaultUser     = "test"
+	DefaultPassword = "secret"
+)
+
+type Filesystem int
+
+const (
+	// FilesystemReal serves the files of this machine over sftp and runs
+	// commands without a handler with sh.
+	FilesystemReal Filesystem = iota
+	// FilesystemMemory serves an in-memory tree over sftp, which starts empty,
+	// and answers commands without a handler with NotFound.
+	FilesystemMemory
+)
+
+// Server is an ssh server listening on a random port of 127.0.0.1. The
+// DefaultUser can log in with DefaultPassword. Every setting can be changed
+// while the server is running.
+type Server struct {
+	listener   net.Listener
+	hostKey    ssh.Signer
+	lock       sync.Mutex
+	passwords  map[string]string
+	keys       map[string][][]byte
+	handlers   map[string]Handler
+	fallback   Handler
+	fs         Filesystem
+	memory     sftp.Handlers
+	sftp       bool
+	forwarding bool
+	commands   []string
+	conns      map[*ssh.ServerConn]struct{}
+	closed     bool
+}
+
+// NewServer starts a server with sftp and port forwarding enabled, on the real filesystem.
+func NewServer() *Server {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("sshtest: failed to generate a host key: %v", err))
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		panic(fmt.Sprintf("sshtest: failed to generate a host key: %v", err))
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("sshtest: failed to listen: %v", err))
+	}
+	s := &Server{
+		listener:   listener,
+		hostKey:    signer,
+		passwords:  map[string]string{DefaultUser: DefaultPassword},
+		keys:       make(map[string][][]byte),
+		handlers:   make(map[string]Handler),
+		sftp:       true,
+		forwarding: true,
+		conns:      make(map[*ssh.ServerConn]struct{}),
+	}
+	go s.serve()
+	return s
+}
+
+// Addr returns the address the server listens on, "127.0.0.1:port".
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Port() uint16 {
+	return uint16(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+// SetPassword lets user log in with password, with keyboard-interactive
+// authentication as well.
+func (s *Server) SetPassword(user string, password string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.passwords[user] = password
+}
+
+// AddAuthorizedKey lets user log in with the private key of key.
+func (s *Server) AddAuthorizedKey(user string, key ssh.PublicKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys[user] = append(s.keys[user], key.Marshal())
+}
+
+// Handle runs handler for the commands equal to command.
+func (s *Server) Handle(command string, handler Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[command] = handler
+}
+
+// SetFallback runs handler for the commands without a handler. By default they
+// run with Shell on the real filesystem and NotFound on the in-memory one.
+func (s *Server) SetFallback(handler Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fallback = handler
+}
+
+// SetFilesystem changes the filesystem. Switching to FilesystemMemory starts
+// with an empty tree.
+func (s *Server) SetFilesystem(fs Filesystem) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fs = fs
+	if fs == FilesystemMemory {
+		s.memory = sftp.InMemHandler()
+	}
+}
+
+// SetSftp enables or disables the sftp subsystem for new sessions.
+func (s *Server) SetSftp(enabled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sftp = enabled
+}
+
+// SetForwarding enables or disables local and remote port forwarding.
+func (s *Server) SetForwarding(enabled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.forwarding = enabled
+}
+
+// Commands returns the commands requested so far, in order.
+func (s *Server) Commands() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	commands := make([]string, len(s.commands))
+	copy(commands, s.commands)
+	return commands
+}
+
+// Connections returns the number of client connections currently open.
+func (s *Server) Connections() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.conns)
+}
+
+// CloseConnections closes the connections of every client, as if the network failed.
+func (s *Server) CloseConnections() {
+	s.lock.Lock()
+	conns := make([]*ssh.ServerConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.lock.Unlock()
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+// Close stops listening and closes the connections of every client.
+func (s *Server) Close() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	_ = s.listener.Close()
+	s.CloseConnections()
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) serverConfig() *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, s.checkPassword(conn.User(), string(password))
+		},
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := challenge(conn.User(), "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 {
+				return nil, errors.New("expected one answer")
+			}
+			return nil, s.checkPassword(conn.User(), answers[0])
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			for _, authorized := range s.keys[conn.User()] {
+				if bytes.Equal(authorized, key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(s.hostKey)
+	return config
+}
+
+func (s *Server) checkPassword(user string, password string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	want, ok := s.passwords[user]
+	if !ok || want != password {
+		return errors.New("wrong password")
+	}
+	return nil
+}
+
+func (s *Server) handleConn(netConn net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(netConn, s.serverConfig())
+	if err != nil {
+		_ = netConn.Close()
+		return
+	}
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		_ = conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		_ = conn.Close()
+	}()
+	forwards := &remoteForwards{listeners: make(map[string]net.Listener)}
+	defer forwards.closeAll()
+	go s.globalRequests(conn, reqs, forwards)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go s.session(conn, newChannel)
+		case "direct-tcpip":
+			go s.directTCPIP(newChannel)
+		default:
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+func (s *Server) forwardingEnabled() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.forwarding
+}
+
+// handler returns the handler of command and whether it was registered for it.
+func (s *Server) handler(command string) (Handler, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.commands = append(s.commands, command)
+	handler, ok := s.handlers[command]
+	if ok {
+		return handler, true
+	}
+	if s.fallback != nil {
+		return s.fallback, false
+	}
+	if s.fs == FilesystemMemory {
+		return NotFound, false
+	}
+	return Shell, false
+}
+
+func (s *Server) sftpServer() (bool, Filesystem, sftp.Handlers) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.sftp, s.fs, s.memory
+}
+
+func portString(port uint32) string {
+	return strconv.FormatUint(uint64(port), 10)
+}
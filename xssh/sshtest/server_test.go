@@ -0,0 +1,247 @@
+package sshtest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"testing"
+)
+
+func dial(t *testing.T, server *Server, auth ssh.AuthMethod) (*ssh.Client, error) {
+	client, err := ssh.Dial("tcp", server.Addr(), &ssh.ClientConfig{
+		User:            DefaultUser,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.FixedHostKey(server.HostKey()),
+	})
+	if err == nil {
+		t.Cleanup(func() {
+			_ = client.Close()
+		})
+	}
+	return client, err
+}
+
+func run(t *testing.T, client *ssh.Client, command string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	err = session.Run(command)
+	return stdout.String(), err
+}
+
+func TestServer_Auth(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	_, err := dial(t, server, ssh.Password("wrong"))
+	if err == nil {
+		t.Fatal("expected a wrong password to fail")
+	}
+	_, err = dial(t, server, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		return []string{DefaultPassword}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dial(t, server, ssh.PublicKeys(signer))
+	if err == nil {
+		t.Fatal("expected an unknown key to fail")
+	}
+	server.AddAuthorizedKey(DefaultUser, signer.PublicKey())
+	_, err = dial(t, server, ssh.PublicKeys(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_Exec(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.SetFilesystem(FilesystemMemory)
+	server.Handle("uname -s", Output("Linux\n", 0))
+	server.Handle("false", Output("", 1))
+	client, err := dial(t, server, ssh.Password(DefaultPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := run(t, client, "uname -s")
+	if err != nil || stdout != "Linux\n" {
+		t.Fatalf("unexpected output %q %v", stdout, err)
+	}
+	for _, tt := range []struct {
+		command string
+		status  int
+	}{{"false", 1}, {"ls /", 127}} {
+		_, err = run(t, client, tt.command)
+		var exitErr *ssh.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitStatus() != tt.status {
+			t.Errorf("%s: got %v, want exit status %d", tt.command, err, tt.status)
+		}
+	}
+	commands := server.Commands()
+	if len(commands) != 3 || commands[0] != "uname -s" || commands[2] != "ls /" {
+		t.Fatalf("unexpected commands %q", commands)
+	}
+}
+
+func TestServer_Shell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	server := NewServer()
+	defer server.Close()
+	client, err := dial(t, server, ssh.Password(DefaultPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	err = session.Setenv("SSHTEST", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.Output(`echo "$SSHTEST"; exit 3`)
+	var exitErr *ssh.ExitError
+	if string(stdout) != "value\n" || !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Fatalf("unexpected result %q %v", stdout, err)
+	}
+}
+
+func TestServer_Sftp(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.SetFilesystem(FilesystemMemory)
+	client, err := dial(t, server, ssh.Password(DefaultPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sftpClient.Close()
+	file, err := sftpClient.Create("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.Write([]byte("data"))
+	_ = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err = sftpClient.Open("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(file)
+	_ = file.Close()
+	if err != nil || string(data) != "data" {
+		t.Fatalf("unexpected content %q %v", data, err)
+	}
+	server.SetSftp(false)
+	_, err = sftp.NewClient(client)
+	if err == nil {
+		t.Fatal("expected the sftp subsystem to be refused")
+	}
+}
+
+func TestServer_Forwarding(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client, err := dial(t, server, ssh.Password(DefaultPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(c, c)
+				_ = c.Close()
+			}()
+		}
+	}()
+	roundTrip := func(c net.Conn) {
+		defer c.Close()
+		_, err := c.Write([]byte("ping"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		if err != nil || string(buf) != "ping" {
+			t.Fatalf("unexpected reply %q %v", buf, err)
+		}
+	}
+	c, err := client.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(c)
+	remote, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	go func() {
+		c, err := remote.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(c, c)
+		_ = c.Close()
+	}()
+	c, err = net.Dial("tcp", remote.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(c)
+	server.SetForwarding(false)
+	_, err = client.Dial("tcp", echo.Addr().String())
+	if err == nil {
+		t.Fatal("expected forwarding to be refused")
+	}
+}
+
+func TestServer_CloseConnections(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client, err := dial(t, server, ssh.Password(DefaultPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.CloseConnections()
+	err = client.Wait()
+	if err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
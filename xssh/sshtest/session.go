@@ -0,0 +1,214 @@
+package sshtest
+
+import (
+	"github.com/creack/pty"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// terminal is the pseudo-terminal requested for a session.
+type terminal struct {
+	lock sync.Mutex
+	term string
+	size pty.Winsize
+	tty  *os.File
+}
+
+func (t *terminal) resize(cols uint32, rows uint32) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.size = pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)}
+	if t.tty != nil {
+		_ = pty.Setsize(t.tty, &t.size)
+	}
+}
+
+func (s *Server) session(conn *ssh.ServerConn, newChannel ssh.NewChannel) {
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	var env []string
+	var term *terminal
+	signals := make(chan string, 8)
+	started := false
+	for req := range reqs {
+		ok := false
+		switch req.Type {
+		case "env":
+			var payload struct{ Name, Value string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				env = append(env, payload.Name+"="+payload.Value)
+				ok = true
+			}
+		case "pty-req":
+			var payload struct {
+				Term                      string
+				Cols, Rows, Width, Height uint32
+				Modes                     string
+			}
+			if ssh.Unmarshal(req.Payload, &payload) == nil && !started {
+				term = &terminal{term: payload.Term}
+				term.resize(payload.Cols, payload.Rows)
+				ok = true
+			}
+		case "window-change":
+			var payload struct{ Cols, Rows, Width, Height uint32 }
+			if ssh.Unmarshal(req.Payload, &payload) == nil && term != nil {
+				term.resize(payload.Cols, payload.Rows)
+				ok = true
+			}
+		case "signal":
+			var payload struct{ Name string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				select {
+				case signals <- payload.Name:
+				default:
+				}
+				ok = true
+			}
+		case "exec", "shell":
+			var payload struct{ Command string }
+			if req.Type == "exec" && ssh.Unmarshal(req.Payload, &payload) != nil {
+				break
+			}
+			if started {
+				break
+			}
+			started, ok = true, true
+			e := &Exec{
+				User:    conn.User(),
+				Command: payload.Command,
+				Env:     env,
+				Stdin:   ch,
+				Stdout:  ch,
+				Stderr:  ch.Stderr(),
+				Signals: signals,
+			}
+			go s.run(ch, e, req.Type == "shell", term)
+		case "subsystem":
+			var payload struct{ Name string }
+			if ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "sftp" || started {
+				break
+			}
+			enabled, fs, memory := s.sftpServer()
+			if !enabled {
+				break
+			}
+			started, ok = true, true
+			go serveSftp(ch, fs, memory)
+		}
+		if req.WantReply {
+			_ = req.Reply(ok, nil)
+		}
+	}
+	//the client closed the channel, kill what is still running
+	select {
+	case signals <- "KILL":
+	default:
+	}
+	close(signals)
+	_ = ch.Close()
+}
+
+func (s *Server) run(ch ssh.Channel, e *Exec, shell bool, term *terminal) {
+	var status int
+	handler, handled := s.handler(e.Command)
+	switch {
+	case !handled && term != nil && s.realFilesystem():
+		status = runTerminal(e, shell, term)
+	case !handled && shell && s.realFilesystem():
+		status = runShell(e)
+	default:
+		status = handler(e)
+	}
+	_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+	_ = ch.Close()
+}
+
+// realFilesystem returns whether commands without a handler run on this machine.
+func (s *Server) realFilesystem() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.fs == FilesystemReal && s.fallback == nil
+}
+
+// runShell runs sh reading the commands from stdin.
+func runShell(e *Exec) int {
+	cmd := exec.Command("sh")
+	cmd.Env = append(os.Environ(), e.Env...)
+	cmd.Stdin = e.Stdin
+	cmd.Stdout = e.Stdout
+	cmd.Stderr = e.Stderr
+	return exitStatus(cmd.Run())
+}
+
+// runTerminal runs the command, or sh for a shell, attached to a
+// pseudo-terminal.
+func runTerminal(e *Exec, shell bool, term *terminal) int {
+	cmd := exec.Command("sh", "-c", e.Command)
+	if shell {
+		cmd = exec.Command("sh")
+	}
+	cmd.Env = append(os.Environ(), e.Env...)
+	cmd.Env = append(cmd.Env, "TERM="+term.term)
+	term.lock.Lock()
+	tty, err := pty.StartWithSize(cmd, &term.size)
+	term.tty = tty
+	term.lock.Unlock()
+	if err != nil {
+		_, _ = io.WriteString(e.Stderr, err.Error()+"\n")
+		return 127
+	}
+	go func() {
+		_, _ = io.Copy(tty, e.Stdin)
+	}()
+	output := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(e.Stdout, tty)
+		close(output)
+	}()
+	exited := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-exited:
+				return
+			case name, ok := <-e.Signals:
+				if !ok {
+					return
+				}
+				sendSignal(cmd.Process, name)
+			}
+		}
+	}()
+	err = cmd.Wait()
+	close(exited)
+	//the output ends once every process holding the terminal exited
+	select {
+	case <-output:
+	case <-time.After(time.Second):
+	}
+	term.lock.Lock()
+	_ = tty.Close()
+	term.tty = nil
+	term.lock.Unlock()
+	return exitStatus(err)
+}
+
+func serveSftp(ch ssh.Channel, fs Filesystem, memory sftp.Handlers) {
+	if fs == FilesystemMemory {
+		server := sftp.NewRequestServer(ch, memory)
+		_ = server.Serve()
+		_ = server.Close()
+	} else if server, err := sftp.NewServer(ch); err == nil {
+		_ = server.Serve()
+		_ = server.Close()
+	}
+	_ = ch.Close()
+}
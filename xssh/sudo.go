@@ -0,0 +1,215 @@
+package xssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrSudoDenied = errors.New("sudo: elevation denied")
+)
+
+type startFunc func(ctx context.Context, cmd *Cmd) (Process, error)
+
+// startSudo runs cmd through "sudo -S". The password is written to stdin only
+// after sudo prints its prompt, so it never shows up in the process list, and
+// the stdin of cmd is forwarded once sudo has elevated.
+func startSudo(ctx context.Context, cmd *Cmd, password string, start startFunc) (Process, error) {
+	err := cmd.validate()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 8)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	prompt := "[xssh-sudo-" + hex.EncodeToString(nonce) + "]"
+	elevated := "[xssh-sudo-ok-" + hex.EncodeToString(nonce) + "]"
+	stdinReader, stdinWriter := io.Pipe()
+	script := "printf %s " + Quote(elevated) + " >&2 && " + cmd.script(true)
+	sudoCmd := NewCmd("sudo", "-S", "-p", prompt, "--", "sh", "-c", script).Stdin(stdinReader)
+	process, err := start(ctx, sudoCmd)
+	if err != nil {
+		_ = stdinWriter.Close()
+		return nil, err
+	}
+	p := &sudoProcess{
+		Process: process,
+		command: "sudo " + cmd.String(),
+		stdin:   stdinWriter,
+	}
+	p.stderr = &sudoFilter{
+		r:        process.Stderr(),
+		prompt:   []byte(prompt),
+		elevated: []byte(elevated),
+		onPrompt: func(n int) {
+			if n > 1 {
+				_ = process.Kill()
+				return
+			}
+			go func() {
+				_, _ = io.WriteString(stdinWriter, password+"\n")
+			}()
+		},
+		onElevated: func() {
+			if cmd.stdin == nil {
+				_ = stdinWriter.Close()
+				return
+			}
+			go func() {
+				_, err := io.Copy(stdinWriter, cmd.stdin)
+				_ = stdinWriter.CloseWithError(err)
+			}()
+		},
+		onEOF: func() {
+			_ = stdinWriter.Close()
+		},
+	}
+	return p, nil
+}
+
+type sudoProcess struct {
+	Process
+	command string
+	stdin   *io.PipeWriter
+	stderr  *sudoFilter
+}
+
+func (p *sudoProcess) Stderr() io.Reader {
+	return p.stderr
+}
+
+func (p *sudoProcess) Wait() (*Result, error) {
+	_ = p.stdin.Close()
+	result, err := p.Process.Wait()
+	result.Command = p.command
+	if err == nil || p.stderr.isElevated() {
+		return result, err
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) && p.stderr.promptCount() < 2 {
+		return result, err
+	}
+	msg := strings.TrimSpace(p.stderr.preamble())
+	if msg == "" {
+		return result, ErrSudoDenied
+	}
+	return result, fmt.Errorf("%w: %s", ErrSudoDenied, msg)
+}
+
+// sudoFilter removes the sudo prompt and the elevation marker from stderr and
+// reports them as they are seen.
+type sudoFilter struct {
+	r          io.Reader
+	prompt     []byte
+	elevated   []byte
+	onPrompt   func(n int)
+	onElevated func()
+	onEOF      func()
+
+	lock    sync.Mutex
+	prompts int
+	done    bool
+	before  bytes.Buffer
+	pending []byte
+	out     []byte
+	err     error
+}
+
+func (f *sudoFilter) Read(p []byte) (int, error) {
+	for len(f.out) == 0 {
+		if f.err != nil {
+			if !f.isElevated() && f.onEOF != nil {
+				f.onEOF()
+				f.onEOF = nil
+			}
+			f.emit(f.pending)
+			f.pending = nil
+			if len(f.out) == 0 {
+				return 0, f.err
+			}
+			break
+		}
+		buf := make([]byte, 4096)
+		n, err := f.r.Read(buf)
+		f.pending = append(f.pending, buf[:n]...)
+		f.err = err
+		f.scan()
+	}
+	n := copy(p, f.out)
+	f.out = f.out[n:]
+	return n, nil
+}
+
+func (f *sudoFilter) scan() {
+	for !f.isElevated() {
+		promptIndex := bytes.Index(f.pending, f.prompt)
+		elevatedIndex := bytes.Index(f.pending, f.elevated)
+		switch {
+		case promptIndex >= 0 && (elevatedIndex < 0 || promptIndex < elevatedIndex):
+			f.emit(f.pending[:promptIndex])
+			f.pending = f.pending[promptIndex+len(f.prompt):]
+			f.lock.Lock()
+			f.prompts++
+			n := f.prompts
+			f.lock.Unlock()
+			f.onPrompt(n)
+		case elevatedIndex >= 0:
+			f.emit(f.pending[:elevatedIndex])
+			f.pending = f.pending[elevatedIndex+len(f.elevated):]
+			f.lock.Lock()
+			f.done = true
+			f.lock.Unlock()
+			f.onElevated()
+		default:
+			//keep what may be the beginning of a marker
+			keep := len(f.prompt)
+			if len(f.elevated) > keep {
+				keep = len(f.elevated)
+			}
+			keep--
+			if len(f.pending) > keep {
+				f.emit(f.pending[:len(f.pending)-keep])
+				f.pending = f.pending[len(f.pending)-keep:]
+			}
+			return
+		}
+	}
+	f.out = append(f.out, f.pending...)
+	f.pending = nil
+}
+
+func (f *sudoFilter) emit(data []byte) {
+	if !f.isElevated() {
+		f.lock.Lock()
+		f.before.Write(data)
+		f.lock.Unlock()
+	}
+	f.out = append(f.out, data...)
+}
+
+func (f *sudoFilter) isElevated() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.done
+}
+
+func (f *sudoFilter) preamble() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.before.String()
+}
+
+func (f *sudoFilter) promptCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.prompts
+}
@@ -0,0 +1,151 @@
+package xssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeSudo behaves like "sudo -S -p <prompt> -- command..." accepting the password "secret".
+const fakeSudo = `#!/bin/sh
+prompt=$3
+shift 4
+for i in 1 2 3; do
+	printf '%s' "$prompt" >&2
+	read -r password
+	if [ "$password" = "secret" ]; then
+		exec "$@"
+	fi
+	echo "Sorry, try again." >&2
+done
+echo "sudo: 3 incorrect password attempts" >&2
+exit 1
+`
+
+func withFakeSudo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires a POSIX shell")
+	}
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "sudo"), []byte(fakeSudo), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	_ = os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() {
+		_ = os.Setenv("PATH", path)
+	})
+}
+
+func TestLocalSession_Sudo(t *testing.T) {
+	withFakeSudo(t)
+	config := Config{}
+	config.SetSudoPassword("secret")
+	session := &LocalSession{Config: config}
+
+	result, err := session.Exec(context.Background(), NewCmd("cat").Raw(";").Arg("echo", "err").Raw(">&2").Sudo().Stdin(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Stdout) != "payload" || string(result.Stderr) != "err\n" {
+		t.Fatalf("unexpected output %q %q", result.Stdout, result.Stderr)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err = session.Sudo().WriteString(file, "it's $HOME"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := session.Sudo().ReadFile(file)
+	if err != nil || string(data) != "it's $HOME" {
+		t.Fatalf("unexpected content %q %v", data, err)
+	}
+
+	config.SetSudoPassword("wrong")
+	session = &LocalSession{Config: config}
+	_, err = session.Exec(context.Background(), NewCmd("true").Sudo())
+	if !errors.Is(err, ErrSudoDenied) {
+		t.Fatalf("want %v, got %v", ErrSudoDenied, err)
+	}
+}
+
+func TestRemoteSession_SudoShell(t *testing.T) {
+	withFakeSudo(t)
+	server, config := startTestServer(t)
+	config.SetSudoPassword("secret")
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if session.sftpClient() == nil || session.Sudo().(*RemoteSession).sftpClient() != nil {
+		t.Fatal("expected sftp without sudo only")
+	}
+
+	dir := filepath.Join(t.TempDir(), "dir")
+	file := filepath.Join(dir, "it's a file")
+	err = session.Sudo().MakeDirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Sudo().WriteString(file, "it's $HOME")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := session.Sudo().ReadFile(file)
+	if err != nil || string(data) != "it's $HOME" {
+		t.Fatalf("unexpected content %q %v", data, err)
+	}
+	//the names alone exceed the size of a single argument
+	prefix := strings.Repeat("x", 200)
+	for i := 0; i < 1000; i++ {
+		err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%s%04d", prefix, i)), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := session.Sudo().ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1001 || files[0].Name() != "it's a file" || files[0].Size() != int64(len("it's $HOME")) {
+		t.Fatalf("unexpected entries %d %v", len(files), files[0])
+	}
+	if files[1].Name() != prefix+"0000" || files[1].Path() != filepath.Join(dir, prefix+"0000") {
+		t.Fatalf("unexpected entry %v", files[1])
+	}
+	err = session.Sudo().RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(dir)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed, got %v", dir, err)
+	}
+	//without sudo the tree is removed over sftp
+	err = os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(dir)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed, got %v", dir, err)
+	}
+	for _, command := range server.Commands() {
+		//the platform is probed without sudo
+		if !strings.HasPrefix(command, "sudo ") && !strings.Contains(command, "uname") {
+			t.Fatalf("expected every command to run with sudo, got %q", command)
+		}
+	}
+}
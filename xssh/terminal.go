@@ -0,0 +1,292 @@
+package xssh
+
+import (
+	"context"
+	"errors"
+	"github.com/creack/pty"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	DefaultTerm   = "xterm-256color"
+	DefaultWidth  = 80
+	DefaultHeight = 24
+)
+
+type TerminalOptions struct {
+	Term   string
+	Width  int
+	Height int
+	Modes  ssh.TerminalModes
+	// Cmd is run instead of the login shell when set.
+	Cmd *Cmd
+}
+
+func (o TerminalOptions) term() string {
+	if o.Term == "" {
+		return DefaultTerm
+	}
+	return o.Term
+}
+
+func (o TerminalOptions) size() (int, int) {
+	width, height := o.Width, o.Height
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	if height <= 0 {
+		height = DefaultHeight
+	}
+	return width, height
+}
+
+func (o TerminalOptions) modes() ssh.TerminalModes {
+	if o.Modes != nil {
+		return o.Modes
+	}
+	return ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+}
+
+// Terminal is an interactive shell attached to a pseudo-terminal. Stdout and
+// stderr are both read from the terminal, which must be read until EOF before
+// Wait is called. Close releases the terminal and hangs up the shell when it
+// is still running.
+type Terminal interface {
+	io.Reader
+	io.Writer
+	Resize(width int, height int) error
+	Wait() error
+	Close() error
+}
+
+// terminalCmd returns the command to run in a terminal, nil for the login
+// shell. With sudo the password prompt shows up on the terminal itself.
+func terminalCmd(cmd *Cmd, sudo bool) *Cmd {
+	if cmd == nil {
+		if sudo {
+			return NewCmd("sudo", "-i")
+		}
+		return nil
+	}
+	if sudo || cmd.sudo {
+		return NewCmd("sudo", "--", "sh", "-c", cmd.script(true))
+	}
+	return cmd
+}
+
+func terminalResult(host string, cmd *Cmd) *Result {
+	if cmd == nil {
+		return newResult(host, NewCmd("shell"))
+	}
+	return newResult(host, cmd)
+}
+
+func (s *RemoteSession) Shell(ctx context.Context, opts TerminalOptions) (Terminal, error) {
+	err := s.ensureConnected(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cmd := terminalCmd(opts.Cmd, s.sudo)
+	if cmd != nil {
+		err := cmd.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	session, done, err := s.newSession()
+	if err != nil {
+		return nil, err
+	}
+	width, height := opts.size()
+	err = session.RequestPty(opts.term(), height, width, opts.modes())
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	stdout, stdoutWriter := io.Pipe()
+	session.Stdout = stdoutWriter
+	session.Stderr = stdoutWriter
+	result := terminalResult(s.Config.Host(), cmd)
+	if cmd == nil {
+		err = session.Shell()
+	} else {
+		err = session.Start(remoteScript(session, cmd))
+	}
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	t := &remoteTerminal{
+		session: session,
+		ctx:     ctx,
+		cancel:  cancel,
+		exited:  make(chan struct{}),
+		result:  result,
+		stdin:   stdin,
+		stdout:  stdout,
+	}
+	go func() {
+		err := session.Wait()
+		_ = stdoutWriter.Close()
+		if ctx.Err() == nil {
+			err = connectionLost(err, done)
+		}
+		t.err = result.finish(ctx, err)
+		close(t.exited)
+	}()
+	go t.watch()
+	return t, nil
+}
+
+type remoteTerminal struct {
+	session *ssh.Session
+	ctx     context.Context
+	cancel  context.CancelFunc
+	exited  chan struct{}
+	result  *Result
+	stdin   io.WriteCloser
+	stdout  io.Reader
+	err     error
+}
+
+func (t *remoteTerminal) watch() {
+	select {
+	case <-t.ctx.Done():
+		_ = t.Close()
+	case <-t.exited:
+	}
+}
+
+func (t *remoteTerminal) Read(p []byte) (int, error) {
+	return t.stdout.Read(p)
+}
+
+func (t *remoteTerminal) Write(p []byte) (int, error) {
+	return t.stdin.Write(p)
+}
+
+func (t *remoteTerminal) Resize(width int, height int) error {
+	return t.session.WindowChange(height, width)
+}
+
+func (t *remoteTerminal) Wait() error {
+	<-t.exited
+	t.cancel()
+	_ = t.session.Close()
+	return t.err
+}
+
+func (t *remoteTerminal) Close() error {
+	_ = t.session.Signal(ssh.SIGHUP)
+	return t.session.Close()
+}
+
+func (s *LocalSession) Shell(ctx context.Context, opts TerminalOptions) (Terminal, error) {
+	cmd := terminalCmd(opts.Cmd, s.sudo)
+	var c *exec.Cmd
+	if cmd == nil {
+		c = s.loginShell()
+	} else {
+		err := cmd.validateLocal(s.windows())
+		if err != nil {
+			return nil, err
+		}
+		c = s.commandOf(context.Background(), cmd)
+		c.Dir = cmd.dir
+		c.Env = append(os.Environ(), cmd.env...)
+	}
+	if c.Env == nil {
+		c.Env = os.Environ()
+	}
+	c.Env = append(c.Env, "TERM="+opts.term())
+	width, height := opts.size()
+	result := terminalResult(s.Config.Host(), cmd)
+	tty, err := pty.StartWithSize(c, &pty.Winsize{Rows: uint16(height), Cols: uint16(width)})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	t := &localTerminal{
+		cmd:    c,
+		ctx:    ctx,
+		cancel: cancel,
+		exited: make(chan struct{}),
+		result: result,
+		tty:    tty,
+	}
+	go t.watch()
+	return t, nil
+}
+
+func (s *LocalSession) loginShell() *exec.Cmd {
+	if !s.windows() {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "sh"
+		}
+		return exec.Command(shell)
+	} else {
+		return exec.Command("cmd")
+	}
+}
+
+type localTerminal struct {
+	cmd    *exec.Cmd
+	ctx    context.Context
+	cancel context.CancelFunc
+	exited chan struct{}
+	result *Result
+	tty    *os.File
+}
+
+func (t *localTerminal) watch() {
+	select {
+	case <-t.ctx.Done():
+		_ = t.Close()
+	case <-t.exited:
+	}
+}
+
+// Read returns io.EOF once the shell has exited. Linux reports EIO on the
+// terminal when its last writer is gone.
+func (t *localTerminal) Read(p []byte) (int, error) {
+	n, err := t.tty.Read(p)
+	if err != nil && (errors.Is(err, syscall.EIO) || errors.Is(err, os.ErrClosed)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (t *localTerminal) Write(p []byte) (int, error) {
+	return t.tty.Write(p)
+}
+
+func (t *localTerminal) Resize(width int, height int) error {
+	return pty.Setsize(t.tty, &pty.Winsize{Rows: uint16(height), Cols: uint16(width)})
+}
+
+func (t *localTerminal) Wait() error {
+	err := t.result.finish(t.ctx, t.cmd.Wait())
+	close(t.exited)
+	t.cancel()
+	return err
+}
+
+func (t *localTerminal) Close() error {
+	_ = t.cmd.Process.Kill()
+	return t.tty.Close()
+}
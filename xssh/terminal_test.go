@@ -0,0 +1,99 @@
+package xssh
+
+import (
+	"context"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLocalSession_Shell(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires a POSIX shell")
+	}
+	session := &LocalSession{}
+	terminal, err := session.Shell(context.Background(), TerminalOptions{
+		Width:  100,
+		Height: 30,
+		Cmd:    NewCmd("sh", "-c", "stty size; read x; stty size; echo \"$TERM got $x\""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer terminal.Close()
+	err = terminal.Resize(120, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = terminal.Write([]byte("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadAll(terminal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = terminal.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"40 120", DefaultTerm + " got hello"} {
+		if !strings.Contains(string(output), want) {
+			t.Fatalf("output %q does not contain %q", output, want)
+		}
+	}
+}
+
+func TestRemoteSession_Shell(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the test server runs commands with sh")
+	}
+	_, config := startTestServer(t)
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	terminal, err := session.Shell(context.Background(), TerminalOptions{
+		Width:  100,
+		Height: 30,
+		//the window change arrives apart from the input, wait for it
+		Cmd: NewCmd("sh", "-c", "stty size; i=0; while [ \"$(stty size)\" = '30 100' ] && [ $i -lt 500 ]; do i=$((i+1)); sleep 0.01; done; stty size; read x; echo \"$TERM got $x\""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer terminal.Close()
+	output := make([]byte, 0)
+	buf := make([]byte, 256)
+	for !strings.Contains(string(output), "30 100") {
+		n, err := terminal.Read(buf)
+		if err != nil {
+			t.Fatalf("unexpected output %q %v", output, err)
+		}
+		output = append(output, buf[:n]...)
+	}
+	err = terminal.Resize(120, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = terminal.Write([]byte("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(terminal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = terminal.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"40 120", DefaultTerm + " got hello"} {
+		if !strings.Contains(string(rest), want) {
+			t.Fatalf("output %q does not contain %q", rest, want)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package xssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrSftpUnavailable  = errors.New("sftp subsystem is not available")
+	ErrSudoTransfer     = errors.New("file transfers are not supported with sudo")
+)
+
+const transferBufferSize = 256 * 1024
+
+// PartSuffix is appended to the destination of a resumable transfer until it is complete.
+const PartSuffix = ".part"
+
+// Progress reports the state of a transfer. Sizes are -1 when unknown, e.g.
+// when uploading from an io.Reader.
+type Progress struct {
+	// Path is the source path of the file being transferred.
+	Path       string
+	Bytes      int64
+	Size       int64
+	TotalBytes int64
+	TotalSize  int64
+}
+
+type ProgressFunc func(progress Progress)
+
+type TransferOptions struct {
+	// Progress is called after every chunk, never concurrently.
+	Progress ProgressFunc
+	// Preserve copies the mode and the modification time of the source.
+	Preserve bool
+	// Verify compares the sha256 of the source and the destination of every file after it is written.
+	Verify bool
+	// Mode is the mode of files created from an io.Reader, 0644 by default.
+	Mode os.FileMode
+	// Size is the number of bytes UploadFrom reads from its reader, when it is
+	// positive. It is otherwise taken from readers implementing io.Seeker,
+	// e.g. an *os.File, if possible. Without a size, uploads over scp spool
+	// the reader to a local temporary file first, since scp announces the size
+	// of a file before its content.
+	Size int64
+	// Resume makes Upload and Download write every file next to its
+	// destination with PartSuffix appended, renaming it into place once
+	// complete. A part file left by an interrupted transfer is continued when
+	// it is a prefix of the source, otherwise it is started over. scp can
+	// neither continue nor rename a file, Upload and Download fail with
+	// ErrScpResume when the host has no sftp subsystem.
+	Resume bool
+}
+
+func (o TransferOptions) mode() os.FileMode {
+	if o.Mode == 0 {
+		return 0644
+	}
+	return o.Mode.Perm()
+}
+
+// transfer copies the file or the directory tree srcPath of src to dstPath of
+// dst. dstPath is the path of the copy itself, not of its parent directory.
+// Entries which are neither regular files nor directories are skipped.
+func transfer(ctx context.Context, src transferFS, srcPath string, dst transferFS, dstPath string, opts TransferOptions) error {
+	info, err := src.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	t := &transferrer{ctx: ctx, src: src, dst: dst, opts: opts}
+	if info.IsDir() {
+		t.totalSize, err = t.size(srcPath)
+		if err != nil {
+			return err
+		}
+		return t.dir(srcPath, dstPath, info)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", srcPath)
+	}
+	t.totalSize = info.Size()
+	err = dst.MkdirAll(dst.Dir(dstPath), 0755)
+	if err != nil {
+		return err
+	}
+	return t.file(srcPath, dstPath, info)
+}
+
+type transferrer struct {
+	ctx        context.Context
+	src        transferFS
+	dst        transferFS
+	opts       TransferOptions
+	totalBytes int64
+	totalSize  int64
+}
+
+func (t *transferrer) size(dir string) (int64, error) {
+	infos, err := t.src.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, info := range infos {
+		if info.IsDir() {
+			n, err := t.size(t.src.Join(dir, info.Name()))
+			if err != nil {
+				return 0, err
+			}
+			size += n
+		} else if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+func (t *transferrer) dir(srcPath string, dstPath string, info os.FileInfo) error {
+	err := t.dst.MkdirAll(dstPath, info.Mode().Perm()|0700)
+	if err != nil {
+		return err
+	}
+	infos, err := t.src.ReadDir(srcPath)
+	if err != nil {
+		return err
+	}
+	for _, child := range infos {
+		childSrc := t.src.Join(srcPath, child.Name())
+		childDst := t.dst.Join(dstPath, child.Name())
+		switch {
+		case child.IsDir():
+			err = t.dir(childSrc, childDst, child)
+		case child.Mode().IsRegular():
+			err = t.file(childSrc, childDst, child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	//the times of a directory change with its content, so they are set last
+	return t.preserve(dstPath, info)
+}
+
+func (t *transferrer) file(srcPath string, dstPath string, info os.FileInfo) error {
+	target := dstPath
+	if t.opts.Resume {
+		target = dstPath + PartSuffix
+	}
+	r, err := t.src.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	w, sum, offset, err := t.resume(r, target, info.Size())
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		if offset > 0 {
+			//the part file did not match, start over
+			_ = r.Close()
+			r, err = t.src.Open(srcPath)
+			if err != nil {
+				return err
+			}
+		}
+		w, err = t.dst.Create(target, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		sum, offset = sha256.New(), 0
+	}
+	err = t.copy(w, r, srcPath, info.Size(), offset, sum)
+	closeErr := w.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	err = t.preserve(target, info)
+	if err != nil {
+		return err
+	}
+	err = t.verify(t.dst.Sum, target, sum)
+	if err != nil {
+		return err
+	}
+	if target != dstPath {
+		return t.dst.Rename(target, dstPath)
+	}
+	return nil
+}
+
+// resume opens the part file target for appending when it holds a prefix of
+// r, in which case sum covers that prefix. Otherwise w is nil and offset is
+// the number of bytes read from r for the comparison.
+func (t *transferrer) resume(r io.Reader, target string, size int64) (w io.WriteCloser, sum hash.Hash, offset int64, err error) {
+	if !t.opts.Resume {
+		return nil, nil, 0, nil
+	}
+	partial, err := t.dst.Stat(target)
+	if err != nil || !partial.Mode().IsRegular() || partial.Size() == 0 || partial.Size() > size {
+		return nil, nil, 0, nil
+	}
+	offset = partial.Size()
+	sum = sha256.New()
+	_, err = io.CopyN(sum, r, offset)
+	if err != nil {
+		return nil, nil, offset, err
+	}
+	got, err := t.dst.Sum(target, offset)
+	if err != nil || got != hex.EncodeToString(sum.Sum(nil)) {
+		return nil, nil, offset, nil
+	}
+	w, err = t.dst.Append(target)
+	if err != nil {
+		return nil, nil, offset, err
+	}
+	return w, sum, offset, nil
+}
+
+func (t *transferrer) preserve(dstPath string, info os.FileInfo) error {
+	if !t.opts.Preserve {
+		return nil
+	}
+	err := t.dst.Chmod(dstPath, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	return t.dst.Chtimes(dstPath, info.ModTime(), info.ModTime())
+}
+
+// sumFunc returns the hex encoded sha256 of the first n bytes of name, of all of it when n is negative.
+type sumFunc func(name string, n int64) (string, error)
+
+func (t *transferrer) verify(sumOf sumFunc, name string, sum hash.Hash) error {
+	if !t.opts.Verify {
+		return nil
+	}
+	want := hex.EncodeToString(sum.Sum(nil))
+	got, err := sumOf(name, -1)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%w: %s has sha256 %s, want %s", ErrChecksumMismatch, name, got, want)
+	}
+	return nil
+}
+
+// copy copies r to w, adding the data to sum, reporting progress and
+// stopping when ctx is done. The first offset bytes of the file were
+// transferred before.
+func (t *transferrer) copy(w io.Writer, r io.Reader, name string, size int64, offset int64, sum hash.Hash) error {
+	buf := make([]byte, transferBufferSize)
+	written := offset
+	t.totalBytes += offset
+	for {
+		err := t.ctx.Err()
+		if err != nil {
+			return err
+		}
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			_, err = w.Write(buf[:n])
+			if err != nil {
+				return err
+			}
+			sum.Write(buf[:n])
+			written += int64(n)
+			t.totalBytes += int64(n)
+			if t.opts.Progress != nil {
+				t.opts.Progress(Progress{
+					Path:       name,
+					Bytes:      written,
+					Size:       size,
+					TotalBytes: t.totalBytes,
+					TotalSize:  t.totalSize,
+				})
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// upload writes r to dstPath of dst.
+func upload(ctx context.Context, r io.Reader, dst transferFS, dstPath string, opts TransferOptions) error {
+	size, ok := readerSize(r, opts)
+	if !ok {
+		size = -1
+	}
+	t := &transferrer{ctx: ctx, dst: dst, opts: opts, totalSize: size}
+	err := dst.MkdirAll(dst.Dir(dstPath), 0755)
+	if err != nil {
+		return err
+	}
+	w, err := dst.Create(dstPath, opts.mode())
+	if err != nil {
+		return err
+	}
+	sum := sha256.New()
+	err = t.copy(w, r, "", size, 0, sum)
+	closeErr := w.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return t.verify(dst.Sum, dstPath, sum)
+}
+
+// readerSize returns the number of bytes left in r, from opts.Size or by
+// seeking to its end and back.
+func readerSize(r io.Reader, opts TransferOptions) (int64, bool) {
+	if opts.Size > 0 {
+		return opts.Size, true
+	}
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	_, err = seeker.Seek(offset, io.SeekStart)
+	if err != nil || end < offset {
+		return 0, false
+	}
+	return end - offset, true
+}
+
+// download writes srcPath of src to w.
+func download(ctx context.Context, src transferFS, srcPath string, w io.Writer, opts TransferOptions) error {
+	info, err := src.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	r, err := src.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	t := &transferrer{ctx: ctx, src: src, opts: opts, totalSize: info.Size()}
+	sum := sha256.New()
+	err = t.copy(w, r, srcPath, info.Size(), 0, sum)
+	if err != nil {
+		return err
+	}
+	return t.verify(src.Sum, srcPath, sum)
+}
+
+func (s *LocalSession) transferFS() (transferFS, error) {
+	if s.sudo {
+		return nil, ErrSudoTransfer
+	}
+	return localFS{}, nil
+}
+
+func (s *LocalSession) Upload(ctx context.Context, localPath string, remotePath string, opts TransferOptions) error {
+	fs, err := s.transferFS()
+	if err != nil {
+		return err
+	}
+	return transfer(ctx, localFS{}, localPath, fs, remotePath, opts)
+}
+
+func (s *LocalSession) Download(ctx context.Context, remotePath string, localPath string, opts TransferOptions) error {
+	fs, err := s.transferFS()
+	if err != nil {
+		return err
+	}
+	return transfer(ctx, fs, remotePath, localFS{}, localPath, opts)
+}
+
+func (s *LocalSession) UploadFrom(ctx context.Context, r io.Reader, remotePath string, opts TransferOptions) error {
+	fs, err := s.transferFS()
+	if err != nil {
+		return err
+	}
+	return upload(ctx, r, fs, remotePath, opts)
+}
+
+func (s *LocalSession) DownloadTo(ctx context.Context, remotePath string, w io.Writer, opts TransferOptions) error {
+	fs, err := s.transferFS()
+	if err != nil {
+		return err
+	}
+	return download(ctx, fs, remotePath, w, opts)
+}
+
+// transferFS returns ErrSftpUnavailable when the host has no sftp subsystem,
+// in which case the transfers fall back to scp.
+func (s *RemoteSession) transferFS(ctx context.Context) (transferFS, error) {
+	err := s.ensureConnected(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.sudo {
+		return nil, ErrSudoTransfer
+	}
+	_, client, _ := s.current()
+	if client == nil {
+		return nil, ErrSftpUnavailable
+	}
+	return sftpFS{client: client, exec: s.exec}, nil
+}
+
+func (s *RemoteSession) Upload(ctx context.Context, localPath string, remotePath string, opts TransferOptions) error {
+	fs, err := s.transferFS(ctx)
+	if errors.Is(err, ErrSftpUnavailable) {
+		return s.scpUpload(ctx, localPath, remotePath, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return transfer(ctx, localFS{}, localPath, fs, remotePath, opts)
+}
+
+func (s *RemoteSession) Download(ctx context.Context, remotePath string, localPath string, opts TransferOptions) error {
+	fs, err := s.transferFS(ctx)
+	if errors.Is(err, ErrSftpUnavailable) {
+		return s.scpDownload(ctx, remotePath, localPath, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return transfer(ctx, fs, remotePath, localFS{}, localPath, opts)
+}
+
+func (s *RemoteSession) UploadFrom(ctx context.Context, r io.Reader, remotePath string, opts TransferOptions) error {
+	fs, err := s.transferFS(ctx)
+	if errors.Is(err, ErrSftpUnavailable) {
+		return s.scpUploadFrom(ctx, r, remotePath, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return upload(ctx, r, fs, remotePath, opts)
+}
+
+func (s *RemoteSession) DownloadTo(ctx context.Context, remotePath string, w io.Writer, opts TransferOptions) error {
+	fs, err := s.transferFS(ctx)
+	if errors.Is(err, ErrSftpUnavailable) {
+		return s.scpDownloadTo(ctx, remotePath, w, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return download(ctx, fs, remotePath, w, opts)
+}
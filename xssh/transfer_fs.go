@@ -0,0 +1,224 @@
+package xssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/sftp"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transferFS is one side of a file transfer.
+type transferFS interface {
+	Join(elem ...string) string
+	Dir(name string) string
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	// Create truncates name or creates it with perm.
+	Create(name string, perm os.FileMode) (io.WriteCloser, error)
+	MkdirAll(name string, perm os.FileMode) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	// Append opens name for writing at its end.
+	Append(name string) (io.WriteCloser, error)
+	// Rename replaces newname with oldname.
+	Rename(oldname string, newname string) error
+	// Sum returns the hex encoded sha256 of the first n bytes of name, of all of it when n is negative.
+	Sum(name string, n int64) (string, error)
+}
+
+func sumReader(r io.Reader, n int64) (string, error) {
+	if n >= 0 {
+		r = io.LimitReader(r, n)
+	}
+	hash := sha256.New()
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type localFS struct{}
+
+func (localFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (localFS) Dir(name string) string {
+	return filepath.Dir(name)
+}
+
+func (localFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (localFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+func (localFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (localFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+func (localFS) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (localFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (localFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (localFS) Append(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+}
+
+func (localFS) Rename(oldname string, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (localFS) Sum(name string, n int64) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return sumReader(file, n)
+}
+
+// sftpFS transfers files over sftp. Checksums are computed on the remote host
+// with sha256sum when exec is set, so the file does not travel twice.
+type sftpFS struct {
+	client *sftp.Client
+	exec   execFunc
+}
+
+func (sftpFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (sftpFS) Dir(name string) string {
+	return path.Dir(name)
+}
+
+func (f sftpFS) Stat(name string) (os.FileInfo, error) {
+	return f.client.Stat(name)
+}
+
+func (f sftpFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return f.client.ReadDir(name)
+}
+
+func (f sftpFS) Open(name string) (io.ReadCloser, error) {
+	return f.client.Open(name)
+}
+
+func (f sftpFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	_, err := f.client.Stat(name)
+	exists := err == nil
+	file, err := f.client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		err = file.Chmod(perm)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+func (f sftpFS) MkdirAll(name string, perm os.FileMode) error {
+	_, err := f.client.Stat(name)
+	if err == nil {
+		return nil
+	}
+	err = f.client.MkdirAll(name)
+	if err != nil {
+		return err
+	}
+	return f.client.Chmod(name, perm)
+}
+
+func (f sftpFS) Chmod(name string, mode os.FileMode) error {
+	return f.client.Chmod(name, mode)
+}
+
+func (f sftpFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return f.client.Chtimes(name, atime, mtime)
+}
+
+func (f sftpFS) Append(name string) (io.WriteCloser, error) {
+	file, err := f.client.OpenFile(name, os.O_WRONLY)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Rename falls back to removing newname when the server lacks the posix-rename extension.
+func (f sftpFS) Rename(oldname string, newname string) error {
+	err := f.client.PosixRename(oldname, newname)
+	if err == nil {
+		return nil
+	}
+	err = f.client.Remove(newname)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return f.client.Rename(oldname, newname)
+}
+
+func (f sftpFS) Sum(name string, n int64) (string, error) {
+	if f.exec != nil {
+		sum, ok := remoteSum(f.exec, name, n)
+		if ok {
+			return sum, nil
+		}
+	}
+	file, err := f.client.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return sumReader(file, n)
+}
+
+// remoteSum runs sha256sum on the remote host. It reports false when the
+// command is not available or failed.
+func remoteSum(exec execFunc, name string, n int64) (string, bool) {
+	cmd := NewCmd("sha256sum", "--", name)
+	if n >= 0 {
+		cmd = NewCmd("head", "-c", strconv.FormatInt(n, 10), "--", name).Raw("|").Arg("sha256sum")
+	}
+	output := &strings.Builder{}
+	_, err := exec(context.Background(), cmd, output, nil)
+	fields := strings.Fields(output.String())
+	if err != nil || len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", false
+	}
+	return fields[0], true
+}
@@ -0,0 +1,307 @@
+package xssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestTree(t *testing.T, root string) time.Time {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := map[string]string{
+		"a.txt":         "hello",
+		"sub/b.bin":     strings.Repeat("x", 3*transferBufferSize+7),
+		"sub/deep/c.sh": "#!/bin/sh\n",
+	}
+	for name, data := range files {
+		name = filepath.Join(root, name)
+		err := os.MkdirAll(filepath.Dir(name), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(name, []byte(data), 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(name, mtime, mtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.Chmod(filepath.Join(root, "sub/deep/c.sh"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mtime
+}
+
+func TestLocalSession_Upload(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+	mtime := writeTestTree(t, src)
+	var last Progress
+	session := &LocalSession{}
+	err := session.Upload(context.Background(), src, dst, TransferOptions{
+		Preserve: true,
+		Verify:   true,
+		Progress: func(progress Progress) {
+			last = progress
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.TotalSize != int64(3*transferBufferSize+7+5+10) || last.TotalBytes != last.TotalSize {
+		t.Fatalf("unexpected progress %+v", last)
+	}
+	info, err := os.Stat(filepath.Join(dst, "sub/deep/c.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("mode and mtime not preserved: %v %v", info.Mode(), info.ModTime())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dst, "sub/b.bin"))
+	if err != nil || len(data) != 3*transferBufferSize+7 {
+		t.Fatalf("unexpected copy %d %v", len(data), err)
+	}
+}
+
+func TestLocalSession_UploadFrom(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "dir", "file")
+	session := &LocalSession{}
+	err := session.UploadFrom(context.Background(), strings.NewReader("streamed"), name, TransferOptions{Verify: true, Mode: 0600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	err = session.DownloadTo(context.Background(), name, buf, TransferOptions{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "streamed" || info.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected file %q %v", buf.String(), info.Mode())
+	}
+}
+
+type corruptFS struct {
+	localFS
+}
+
+func (corruptFS) Sum(name string, n int64) (string, error) {
+	return strings.Repeat("0", 64), nil
+}
+
+func TestTransfer_VerifyMismatch(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	err := ioutil.WriteFile(src, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transfer(context.Background(), localFS{}, src, corruptFS{}, filepath.Join(dir, "dst"), TransferOptions{Verify: true})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestLocalSession_UploadResume(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	data := strings.Repeat("0123456789", transferBufferSize/5)
+	err := ioutil.WriteFile(src, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		partial string
+		first   int64
+	}{
+		{name: "prefix", partial: data[:transferBufferSize], first: int64(len(data))},
+		{name: "mismatch", partial: "garbage", first: transferBufferSize},
+		{name: "too long", partial: data + "!", first: transferBufferSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ioutil.WriteFile(dst+PartSuffix, []byte(tt.partial), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var first int64 = -1
+			session := &LocalSession{}
+			err = session.Upload(context.Background(), src, dst, TransferOptions{
+				Resume: true,
+				Verify: true,
+				Progress: func(progress Progress) {
+					if first < 0 {
+						first = progress.Bytes
+					}
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first != tt.first {
+				t.Fatalf("expected first progress at %d, got %d", tt.first, first)
+			}
+			got, err := ioutil.ReadFile(dst)
+			if err != nil || string(got) != data {
+				t.Fatalf("unexpected copy of %d bytes: %v", len(got), err)
+			}
+			_, err = os.Stat(dst + PartSuffix)
+			if !os.IsNotExist(err) {
+				t.Fatalf("part file left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestReaderSize(t *testing.T) {
+	r := strings.NewReader("0123456789")
+	_, _ = r.Seek(4, io.SeekStart)
+	if size, ok := readerSize(r, TransferOptions{}); !ok || size != 6 {
+		t.Fatalf("got %d %v, want the 6 bytes left", size, ok)
+	}
+	if b, _ := r.ReadByte(); b != '4' {
+		t.Fatal("expected the offset to be restored")
+	}
+	if _, ok := readerSize(struct{ io.Reader }{r}, TransferOptions{}); ok {
+		t.Fatal("expected no size without io.Seeker")
+	}
+	if size, ok := readerSize(struct{ io.Reader }{r}, TransferOptions{Size: 3}); !ok || size != 3 {
+		t.Fatalf("got %d %v, want the size of the options", size, ok)
+	}
+}
+
+func TestRemoteSession_UploadDownload(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the test server runs commands with sh")
+	}
+	_, config := startTestServer(t)
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	src := filepath.Join(t.TempDir(), "src")
+	remote := filepath.Join(t.TempDir(), "remote")
+	dst := filepath.Join(t.TempDir(), "dst")
+	mtime := writeTestTree(t, src)
+	opts := TransferOptions{Preserve: true, Verify: true}
+	err = session.Upload(context.Background(), src, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Download(context.Background(), remote, dst, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{remote, dst} {
+		info, err := os.Stat(filepath.Join(dir, "sub/deep/c.sh"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0750 || !info.ModTime().Equal(mtime) {
+			t.Fatalf("%s: mode and mtime not preserved: %v %v", dir, info.Mode(), info.ModTime())
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "sub/b.bin"))
+		if err != nil || len(data) != 3*transferBufferSize+7 {
+			t.Fatalf("%s: unexpected copy %d %v", dir, len(data), err)
+		}
+	}
+}
+
+func TestRemoteSession_TransferResume(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the test server runs commands with sh")
+	}
+	_, config := startTestServer(t)
+	session := &RemoteSession{Config: config}
+	err := session.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	dir := t.TempDir()
+	data := strings.Repeat("0123456789", transferBufferSize/2)
+	src := filepath.Join(dir, "src")
+	err = ioutil.WriteFile(src, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfers := []struct {
+		name string
+		run  func(ctx context.Context, dst string, opts TransferOptions) error
+	}{
+		{name: "upload", run: func(ctx context.Context, dst string, opts TransferOptions) error {
+			return session.Upload(ctx, src, dst, opts)
+		}},
+		{name: "download", run: func(ctx context.Context, dst string, opts TransferOptions) error {
+			return session.Download(ctx, src, dst, opts)
+		}},
+	}
+	for _, tt := range transfers {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "dst")
+			//the first transfer is interrupted after its first chunk
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err := tt.run(ctx, dst, TransferOptions{
+				Resume: true,
+				Progress: func(Progress) {
+					cancel()
+				},
+			})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("want %v, got %v", context.Canceled, err)
+			}
+			partial, err := os.Stat(dst + PartSuffix)
+			if err != nil || partial.Size() == 0 || partial.Size() >= int64(len(data)) {
+				t.Fatalf("expected a partial part file, got %v %v", partial, err)
+			}
+			_, err = os.Stat(dst)
+			if !os.IsNotExist(err) {
+				t.Fatalf("expected no destination before completion, got %v", err)
+			}
+			var first int64 = -1
+			err = tt.run(context.Background(), dst, TransferOptions{
+				Resume: true,
+				Verify: true,
+				Progress: func(progress Progress) {
+					if first < 0 {
+						first = progress.Bytes
+					}
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first != partial.Size()+transferBufferSize && first != int64(len(data)) {
+				t.Fatalf("expected to continue after %d bytes, first progress at %d", partial.Size(), first)
+			}
+			got, err := ioutil.ReadFile(dst)
+			if err != nil || string(got) != data {
+				t.Fatalf("unexpected copy of %d bytes: %v", len(got), err)
+			}
+			_, err = os.Stat(dst + PartSuffix)
+			if !os.IsNotExist(err) {
+				t.Fatalf("part file left behind: %v", err)
+			}
+		})
+	}
+}
@@ -1,19 +1,13 @@
 package xssh
 
 import (
+	"bytes"
+	"io"
 	"os"
 	"strings"
+	"sync"
 )
 
-type FileInfo struct {
-	Name string
-	Path string
-}
-
-func (f *FileInfo) IsDir() bool {
-	return strings.HasSuffix(f.Name, "/") || (f.Name == "" && strings.HasSuffix(f.Path, "/"))
-}
-
 func Dir(path string) string {
 	split := strings.Split(path, "/")
 	if len(split) > 1 {
@@ -41,8 +35,28 @@ func Exists(path string) bool {
 }
 
 func Command(name string, arg ...string) string {
-	if arg != nil {
-		name += " " + strings.Join(arg, " ")
+	return NewCmd(name, arg...).String()
+}
+
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Bytes()
+}
+
+func closeAll(closers ...io.Closer) {
+	for _, closer := range closers {
+		_ = closer.Close()
 	}
-	return name
 }